@@ -2,8 +2,11 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"os"
+	"os/exec"
 	"time"
 
 	"github.com/google/uuid"
@@ -38,11 +41,23 @@ func main() {
 	defer closeLogFile()
 	defer panicHandler()
 
-	cfg, err := config.LoadConfig()
+	cfg, err := config.LoadConfig(config.GetConfigProfile(os.Args[0]))
 	if err != nil {
 		zlog.Fatal().Err(err).Msg("Failed to load config")
 	}
 
+	// fast path: commands which need no enforcement run the underlying runtime with minimal work
+	if ociruntime.IsPassthrough(cfg, os.Args) {
+		underlyingRuntime, err := ociruntime.NewExecutablePathRuntime(cfg.Runtime)
+		if err != nil {
+			passthroughFatal(cfg, err, "Failed to initialize container runtime")
+		}
+		if err := underlyingRuntime.Exec(os.Args); err != nil {
+			passthroughFatal(cfg, err, "Failed to run container runtime")
+		}
+		return
+	}
+
 	// setup logger
 	logFile = &lumberjack.Logger{
 		Filename:   cfg.Logging.LogFile,
@@ -57,15 +72,43 @@ func main() {
 	}
 	zlog.Logger = zerolog.New(logOutput).With().Timestamp().Str("Execution", uuid.New().String()).Logger().Level(cfg.Logging.LogLevel)
 
-	zlog.Info().Str("version", Version).Msg("Execution Start")
+	zlog.Info().Str("version", Version).Str("profile", cfg.ConfigProfile).Msg("Execution Start")
 	zlog.Debug().Interface("config", cfg).Msg("Config loaded")
+	// the fast path never contacts kubelet, so the warning is logged only for enforced commands
+	if cfg.KubeletInsecureSkipTLSVerify {
+		zlog.Warn().Str("KubeletUrl", cfg.KubeletUrl).Msg("kubelet-insecure-skip-tls-verify is enabled. kubelet's serving certificate is not verified")
+	}
 
 	// run the container runtime
-	containerRuntime, err := ociruntime.NewStrictSupplementalGroups(cfg, logOutput, zlog.Logger.WithContext(context.TODO()))
+	containerRuntime, err := ociruntime.NewStrictSupplementalGroups(cfg, Version, logOutput, zlog.Logger.WithContext(context.TODO()))
 	if err != nil {
-		zlog.Fatal().Err(err).Msg("Failed to initialize container runtime")
+		fatal(err, "Failed to initialize container runtime")
 	}
 	if err := containerRuntime.Exec(os.Args); err != nil {
-		zlog.Fatal().Err(err).Msg("Failed to run container runtime")
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			// the runtime run as a child process has reported the error by itself
+			zlog.Error().Err(err).Msg("Container runtime failed")
+			closeLogFile()
+			os.Exit(exitErr.ExitCode())
+		}
+		fatal(err, "Failed to run container runtime")
+	}
+}
+
+// fatal prints the error concisely to stderr as runc does, and exits after logging it
+func fatal(err error, msg string) {
+	fmt.Fprintln(os.Stderr, err)
+	zlog.Fatal().Err(err).Msg(msg)
+}
+
+// passthroughFatal is fatal for the fast path.  The error is logged to the container log given by the command line
+// as the enforced path does, instead of the log file of this runtime which is not opened in the fast path.
+func passthroughFatal(cfg *config.Config, err error, msg string) {
+	// the log file is closed on exit
+	if logger, _, logErr := ociruntime.NewPassthroughLogger(cfg, os.Args); logErr == nil {
+		logger.Error().Err(err).Msg(msg)
+		zlog.Logger = zerolog.New(io.Discard)
 	}
+	fatal(err, msg)
 }
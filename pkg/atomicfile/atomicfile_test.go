@@ -0,0 +1,27 @@
+package atomicfile
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WriteFile", func() {
+	It("replaces the file without leaving temporary files", func() {
+		dir := GinkgoT().TempDir()
+		path := filepath.Join(dir, "data.json")
+		Expect(WriteFile(path, []byte("old"))).To(Succeed())
+		Expect(WriteFile(path, []byte("new"))).To(Succeed())
+
+		Expect(os.ReadFile(path)).To(Equal([]byte("new")))
+		entries, err := os.ReadDir(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+	})
+
+	It("fails when the directory does not exist", func() {
+		Expect(WriteFile(filepath.Join(GinkgoT().TempDir(), "nonexistent", "data.json"), []byte("data"))).NotTo(Succeed())
+	})
+})
@@ -3,7 +3,9 @@ package config
 import (
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 	"github.com/rs/zerolog"
@@ -12,22 +14,49 @@ import (
 const (
 	defaultConfigDir = "/etc/strict-supplementalgroups-container-runtime"
 	configFileName   = "config.toml"
+	profileConfigDir = "profiles"
+
+	// ConfigProfileEnv is the environment variable to select the config profile
+	ConfigProfileEnv = "STRICT_SUPPLEMENTALGROUPS_CONFIG_PROFILE"
+
+	defaultBinaryName = "strict-supplementalgroups-container-runtime"
 )
 
-func LoadConfig() (*Config, error) {
-	configPath := filepath.Join(defaultConfigDir, configFileName)
+// GetConfigProfile returns the config profile selected by ConfigProfileEnv environment variable, or by
+// the name which the binary is invoked as (argv0) unless it is the default binary name.
+// Empty profile means no profile is selected.
+func GetConfigProfile(argv0 string) string {
+	if profile := os.Getenv(ConfigProfileEnv); profile != "" {
+		return profile
+	}
+	if name := filepath.Base(argv0); name != defaultBinaryName && name != "." && name != "/" {
+		return name
+	}
+	return ""
+}
+
+// LoadConfig loads the config file.  When profile is not empty, the profile's config file
+// (profiles/<profile>.toml) is loaded over the config file.  The profile's config file must exist.
+func LoadConfig(profile string) (*Config, error) {
 	config := getDefaultConfig()
 
 	// load if config file exists
+	configPath := filepath.Join(defaultConfigDir, configFileName)
 	if _, err := os.Stat(configPath); err == nil {
-		configBytes, err := os.ReadFile(configPath)
-		if err != nil {
-			return nil, fmt.Errorf("Failed to read config file %s: %v", configPath, err)
+		if err := loadConfigFile(configPath, config); err != nil {
+			return nil, err
 		}
+	}
 
-		if err := toml.Unmarshal(configBytes, &config); err != nil {
-			return nil, fmt.Errorf("Failed to parse config file %s: %v", configPath, err)
+	if profile != "" {
+		if strings.ContainsRune(profile, filepath.Separator) {
+			return nil, fmt.Errorf("Invalid config profile %s", profile)
+		}
+		profileConfigPath := filepath.Join(defaultConfigDir, profileConfigDir, profile+".toml")
+		if err := loadConfigFile(profileConfigPath, config); err != nil {
+			return nil, fmt.Errorf("Failed to load config profile %s: %w", profile, err)
 		}
+		config.ConfigProfile = profile
 	}
 
 	if err := validateAndCompleteConfig(config); err != nil {
@@ -37,6 +66,18 @@ func LoadConfig() (*Config, error) {
 	return config, nil
 }
 
+func loadConfigFile(configPath string, config *Config) error {
+	configBytes, err := os.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("Failed to read config file %s: %v", configPath, err)
+	}
+
+	if err := toml.Unmarshal(configBytes, config); err != nil {
+		return fmt.Errorf("Failed to parse config file %s: %v", configPath, err)
+	}
+	return nil
+}
+
 func validateAndCompleteConfig(cfg *Config) error {
 	var err error
 
@@ -51,5 +92,120 @@ func validateAndCompleteConfig(cfg *Config) error {
 		return fmt.Errorf("log-format must be test or json")
 	}
 
+	if cfg.KubeletMaxResponseSize <= 0 {
+		return fmt.Errorf("kubelet-max-response-size must be positive")
+	}
+
+	if cfg.PodLookup.RequestTimeout <= 0 {
+		return fmt.Errorf("pod-lookup.request-timeout must be positive")
+	}
+	if cfg.PodLookup.Deadline <= 0 {
+		return fmt.Errorf("pod-lookup.deadline must be positive")
+	}
+	if cfg.PodLookup.Retries < 0 {
+		return fmt.Errorf("pod-lookup.retries must not be negative")
+	}
+	if cfg.PodLookup.Backoff < 0 {
+		return fmt.Errorf("pod-lookup.backoff must not be negative")
+	}
+	if cfg.PodCache.File != "" && cfg.PodCache.TTL <= 0 {
+		return fmt.Errorf("pod-cache.ttl must be positive")
+	}
+
+	profile, err := ResolveRuntimeProfile(cfg)
+	if err != nil {
+		return err
+	}
+	if profile.StateFile != "" && profile.StateFileBundleField == "" {
+		return fmt.Errorf("runtime-profiles: state-file-bundle-field must be set with state-file")
+	}
+	cfg.ResolvedRuntimeProfile = profile
+
+	if err := validateEnforcementAction(cfg.EnforcementAction); err != nil {
+		return fmt.Errorf("enforcement-action: %v", err)
+	}
+
+	switch cfg.MergeSupplementalGroupsPolicy {
+	case MergeSupplementalGroupsPolicyOverride, MergeSupplementalGroupsPolicyRespect:
+	default:
+		return fmt.Errorf("merge-supplemental-groups-policy must be override or respect")
+	}
+
+	switch cfg.EntitlementPolicy {
+	case EntitlementPolicyIntersect, EntitlementPolicyReject:
+	default:
+		return fmt.Errorf("entitlement-policy must be intersect or reject")
+	}
+
+	switch cfg.GroupDatabase.Mode {
+	case GroupDatabaseModeDisabled, GroupDatabaseModeIntersect, GroupDatabaseModeReplace:
+	default:
+		return fmt.Errorf("group-database.mode must be disabled, intersect or replace")
+	}
+
+	switch cfg.Capabilities.Policy {
+	case CapabilitiesPolicyIgnore, CapabilitiesPolicyDrop, CapabilitiesPolicyReject:
+	default:
+		return fmt.Errorf("capabilities.policy must be ignore, drop or reject")
+	}
+	for i, pattern := range cfg.Capabilities.ExemptNamespaces {
+		if err := validatePattern(pattern); err != nil {
+			return fmt.Errorf("capabilities.exempt-namespaces[%d]: %v", i, err)
+		}
+	}
+
+	if cfg.Policy.DefaultAction == "" {
+		cfg.Policy.DefaultAction = cfg.EnforcementAction
+	}
+	if err := validateEnforcementAction(cfg.Policy.DefaultAction); err != nil {
+		return fmt.Errorf("policy.default-action: %v", err)
+	}
+	for i, p := range cfg.Policy.Namespaces {
+		if err := validatePattern(p.Namespace); err != nil {
+			return fmt.Errorf("policy.namespaces[%d].namespace: %v", i, err)
+		}
+		if err := validateEnforcementAction(p.Action); err != nil {
+			return fmt.Errorf("policy.namespaces[%d].action: %v", i, err)
+		}
+	}
+	for i, e := range cfg.Policy.Exemptions {
+		if e.Namespace == "" && e.Pod == "" && e.Container == "" {
+			return fmt.Errorf("policy.exemptions[%d]: at least one of namespace, pod and container must be set", i)
+		}
+		for _, pattern := range []string{e.Namespace, e.Pod, e.Container} {
+			if err := validatePattern(pattern); err != nil {
+				return fmt.Errorf("policy.exemptions[%d]: %v", i, err)
+			}
+		}
+	}
+
+	switch cfg.PrimaryGroupPolicy {
+	case PrimaryGroupPolicyIgnore, PrimaryGroupPolicyForce, PrimaryGroupPolicyRestrict, PrimaryGroupPolicyReject:
+	default:
+		return fmt.Errorf("primary-group-policy must be ignore, force, restrict or reject")
+	}
+
+	switch cfg.RunAsUserPolicy {
+	case RunAsUserPolicyRewrite, RunAsUserPolicyReject:
+	default:
+		return fmt.Errorf("run-as-user-policy must be rewrite or reject")
+	}
+
+	return nil
+}
+
+func validateEnforcementAction(action EnforcementAction) error {
+	switch action {
+	case EnforcementActionDrop, EnforcementActionDeny, EnforcementActionAudit:
+		return nil
+	default:
+		return fmt.Errorf("must be drop, deny or audit")
+	}
+}
+
+func validatePattern(pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %s: %v", pattern, err)
+	}
 	return nil
 }
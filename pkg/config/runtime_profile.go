@@ -0,0 +1,118 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// RuntimeProfileConfig describes the command line and the state of the low-level container runtime
+type RuntimeProfileConfig struct {
+	// DefaultRoot is the root directory of the runtime's state used when --root is not passed
+	DefaultRoot string `toml:"default-root"`
+
+	// StateArgs is the command line arguments (without the runtime binary) to print the state of a container in JSON.
+	// "{root}" and "{id}" are replaced with the root directory and the container id.
+	StateArgs []string `toml:"state-args"`
+
+	// StateBundleField is the dot separated path of the bundle directory in the state JSON
+	StateBundleField string `toml:"state-bundle-field"`
+
+	// StateFile is the path of the runtime's own state file of a container, which is read to resolve the bundle
+	// directory without executing StateArgs.  "{root}" and "{id}" are replaced with the root directory and the container id.
+	// StateArgs is executed when it is empty or it can't be read.
+	StateFile string `toml:"state-file"`
+
+	// StateFileBundleField is the dot separated path of the bundle directory in StateFile.
+	// "<path>=<key>" means the value of "<key>=<value>" formatted element in the string array at <path>
+	// (e.g. "config.labels=bundle" for runc).
+	StateFileBundleField string `toml:"state-file-bundle-field"`
+
+	// Flags is names of the runtime's flags
+	Flags RuntimeFlagsConfig `toml:"flags"`
+}
+
+// RuntimeFlagsConfig is names of the runtime's flags without leading dashes (e.g. ["bundle", "b"]).
+// Empty means the same as runc.
+type RuntimeFlagsConfig struct {
+	// Root is the global flag of the root directory of the runtime's state
+	Root []string `toml:"root"`
+
+	// Log is the global flag of the log file
+	Log []string `toml:"log"`
+
+	// LogFormat is the global flag of the log format
+	LogFormat []string `toml:"log-format"`
+
+	// Bundle is the flag of the bundle directory of create/run/restore commands
+	Bundle []string `toml:"bundle"`
+
+	// Process is the flag of the process file of exec command
+	Process []string `toml:"process"`
+}
+
+const (
+	RuntimeProfileRunc        = "runc"
+	RuntimeProfileCrun        = "crun"
+	RuntimeProfileYouki       = "youki"
+	RuntimeProfileRunsc       = "runsc"
+	RuntimeProfileKataRuntime = "kata-runtime"
+)
+
+func runcCompatibleRuntimeProfile(defaultRoot, stateFile, stateFileBundleField string) RuntimeProfileConfig {
+	return RuntimeProfileConfig{
+		DefaultRoot:          defaultRoot,
+		StateArgs:            []string{"--root", "{root}", "state", "{id}"},
+		StateBundleField:     "bundle",
+		StateFile:            stateFile,
+		StateFileBundleField: stateFileBundleField,
+	}
+}
+
+// builtinRuntimeProfiles is the runtime profiles available without configuration.
+// runsc and kata-runtime have no state file per container in the root directory.
+var builtinRuntimeProfiles = map[string]RuntimeProfileConfig{
+	RuntimeProfileRunc:        runcCompatibleRuntimeProfile("/run/runc", "{root}/{id}/state.json", "config.labels=bundle"),
+	RuntimeProfileCrun:        runcCompatibleRuntimeProfile("/run/crun", "{root}/{id}/status", "bundle"),
+	RuntimeProfileYouki:       runcCompatibleRuntimeProfile("/run/youki", "{root}/{id}/state.json", "bundle"),
+	RuntimeProfileRunsc:       runcCompatibleRuntimeProfile("/var/run/runsc", "", ""),
+	RuntimeProfileKataRuntime: runcCompatibleRuntimeProfile("/run/vc", "", ""),
+}
+
+// ResolveRuntimeProfile returns the runtime profile of cfg.  Empty fields of user defined profiles
+// are completed with the built-in profile of the same name, or runc's.
+func ResolveRuntimeProfile(cfg *Config) (RuntimeProfileConfig, error) {
+	name := cfg.RuntimeProfile
+	if name == "" {
+		// infer from the runtime binary (e.g. /usr/local/bin/crun)
+		name = filepath.Base(cfg.Runtime)
+		if _, ok := builtinRuntimeProfiles[name]; !ok {
+			name = RuntimeProfileRunc
+		}
+	}
+
+	builtin, isBuiltin := builtinRuntimeProfiles[name]
+	if !isBuiltin {
+		builtin = builtinRuntimeProfiles[RuntimeProfileRunc]
+	}
+	profile, ok := cfg.RuntimeProfiles[name]
+	if !ok {
+		if !isBuiltin {
+			return RuntimeProfileConfig{}, fmt.Errorf("runtime-profile %s is not defined", name)
+		}
+		return builtin, nil
+	}
+
+	if profile.DefaultRoot == "" {
+		profile.DefaultRoot = builtin.DefaultRoot
+	}
+	if len(profile.StateArgs) == 0 {
+		profile.StateArgs = builtin.StateArgs
+	}
+	if profile.StateBundleField == "" {
+		profile.StateBundleField = builtin.StateBundleField
+	}
+	if profile.StateFile == "" {
+		profile.StateFile, profile.StateFileBundleField = builtin.StateFile, builtin.StateFileBundleField
+	}
+	return profile, nil
+}
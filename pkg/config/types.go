@@ -1,6 +1,8 @@
 package config
 
 import (
+	"time"
+
 	"github.com/rs/zerolog"
 )
 
@@ -10,12 +12,39 @@ type Config struct {
 	// Runtime is the low-level container runtime binary path of strict-supplementalgroups-container-runtime
 	Runtime string `toml:"runtime" default:"runc"`
 
+	// RuntimeProfile is the name of the profile describing the command line and the state of Runtime.
+	// Built-in profiles are "runc", "crun", "youki", "runsc" and "kata-runtime".
+	// When it is empty, the built-in profile named the base name of Runtime is used, or "runc" if none.
+	RuntimeProfile string `toml:"runtime-profile"`
+
+	// RuntimeProfiles is user defined runtime profiles keyed by name.  A profile with a built-in name overrides the built-in one.
+	RuntimeProfiles map[string]RuntimeProfileConfig `toml:"runtime-profiles"`
+
 	// KubeletUrl is the kubelet's HTTP endpoint
 	KubeletUrl string `toml:"kubelet-url" default:"https://127.0.0.1:10250"`
 
 	// KubeConfig is the kubeconfig file path to access to KubeletUrl
 	KubeConfig string `toml:"kubeconfig" default:"/etc/kubernetes/kubelet.conf"`
 
+	// KubeletCAFile is the CA bundle file trusted in addition to the CA in KubeConfig (i.e. the cluster CA) to verify kubelet's
+	// serving certificate.  The default is the kubelet's self-signed certificate used when serverTLSBootstrap is not enabled.
+	// It is ignored when the file does not exist.
+	KubeletCAFile string `toml:"kubelet-ca-file" default:"/var/lib/kubelet/pki/kubelet.crt"`
+
+	// KubeletServerName is the expected server name in kubelet's serving certificate.  When it is empty, the node's hostname
+	// is used for loopback KubeletUrl (e.g. the default) because kubelet's serving certificate is valid for the hostname
+	// but not for 127.0.0.1.  Otherwise, the host in KubeletUrl is used.  Set it when kubelet's --hostname-override differs.
+	KubeletServerName string `toml:"kubelet-server-name"`
+
+	// KubeletInsecureSkipTLSVerify disables verification of kubelet's serving certificate.  This is insecure because
+	// a process impersonating kubelet can feed fake pods.  A warning is logged on each execution
+	// of the enforced commands (passthrough commands never contact kubelet).
+	KubeletInsecureSkipTLSVerify bool `toml:"kubelet-insecure-skip-tls-verify" default:"false"`
+
+	// KubeletMaxResponseSize is the maximum size in bytes of the kubelet's /pods response.
+	// Getting the pod fails when the response exceeds it.
+	KubeletMaxResponseSize int64 `toml:"kubelet-max-response-size" default:"67108864"`
+
 	// PodNamespaceAnnotation is the annotation key in OCI container spec (config.json) representing pod's namespace.
 	// The annotation key depends on CRI(Container Runtime Interface) implementations.  The default value is containerd's.
 	PodNamespaceAnnotation string `toml:"pod-namespace-annotation" default:"io.kubernetes.cri.sandbox-namespace"`
@@ -24,6 +53,10 @@ type Config struct {
 	// The annotation key depends on CRI(Container Runtime Interface) implementations.  The default value is containerd's.
 	PodNameAnnotation string `toml:"pod-name-annotation" default:"io.kubernetes.cri.sandbox-name"`
 
+	// PodUidAnnotation is the annotation key in OCI container spec (config.json) representing pod's uid.
+	// The annotation key depends on CRI(Container Runtime Interface) implementations.  The default value is containerd's.
+	PodUidAnnotation string `toml:"pod-uid-annotation" default:"io.kubernetes.cri.sandbox-uid"`
+
 	// ContainerNameAnnotation the annotation key in OCI container spec (config.json) representing pod's name.
 	// The annotation key depends on CRI(Container Runtime Interface) implementations.  The default value is containerd's.
 	ContainerNameAnnotation string `toml:"container-name-annotation" default:"io.kubernetes.cri.container-name"`
@@ -32,10 +65,243 @@ type Config struct {
 	// The annotation key depends on CRI(Container Runtime Interface) implementations.  The default value is containerd's.
 	ContainerTypeAnnotation string `toml:"container-type-annotation" default:"io.kubernetes.cri.container-type"`
 
+	// EnforcementDecisionDir is the directory to store the enforcement decision made on create per container.
+	// start and exec reuse it instead of getting the pod from kubelet.  The decision is not stored when it is empty.
+	EnforcementDecisionDir string `toml:"enforcement-decision-dir" default:"/run/strict-supplementalgroups-container-runtime/decisions"`
+
+	// EnforcementAction is the action taken when a container violates its pod's SecurityContext.
+	// This can be overridden per namespace by Policy.
+	//   - "drop": corrects the violation (e.g. drops violated gids from additionalGids) and runs the container
+	//   - "deny": fails to run the container with an error describing the violation
+	//   - "audit": only logs the violation and runs the container as is
+	EnforcementAction EnforcementAction `toml:"enforcement-action" default:"drop"`
+
+	// PrimaryGroupPolicy is the policy for process.user.gid when neither the container nor the pod sets runAsGroup.
+	// When runAsGroup is set, process.user.gid is always enforced with it.
+	//   - "restrict": allows the gid only when it is in (supplementalGroups ∪ fsGroup), otherwise rejects the container
+	//   - "force": overwrites the gid with fsGroup, or the first supplementalGroups if fsGroup is not set
+	//   - "reject": rejects the container
+	//   - "ignore": keeps the gid passed from CRI (i.e. the primary group in the container image).
+	//     This is an explicit opt-out: the image can run the container with any gid as its primary group.
+	PrimaryGroupPolicy PrimaryGroupPolicy `toml:"primary-group-policy" default:"restrict"`
+
+	// RunAsUserPolicy is the policy for process.user.uid which is not equal to the effective runAsUser of the container.
+	//   - "rewrite": overwrites the uid with runAsUser
+	//   - "reject": rejects the container
+	RunAsUserPolicy RunAsUserPolicy `toml:"run-as-user-policy" default:"rewrite"`
+
+	// MergeSupplementalGroupsPolicy is the policy for pods with spec.securityContext.supplementalGroupsPolicy=Merge.
+	// Pods with supplementalGroupsPolicy=Strict or without supplementalGroupsPolicy are always enforced.
+	//   - "override": enforces additionalGids as same as Strict
+	//   - "respect": keeps additionalGids merged with gids defined in the container image
+	// Note that on Kubernetes 1.31+ with SupplementalGroupsPolicy feature enabled, API defaulting sets Merge to every pod
+	// not setting supplementalGroupsPolicy, and the runtime can not tell it from Merge set explicitly.
+	// "respect" then skips enforcing additionalGids for all pods except ones setting Strict.
+	MergeSupplementalGroupsPolicy MergeSupplementalGroupsPolicy `toml:"merge-supplemental-groups-policy" default:"override"`
+
+	// EntitlementFile is the path to the entitlement file which maps namespaces to allowed ranges of
+	// uids, gids and supplementalGroups.  No entitlement is checked when it is empty.
+	// See pkg/entitlement for the file format.
+	EntitlementFile string `toml:"entitlement-file"`
+
+	// EntitlementPolicy is the policy for supplementalGroups and fsGroup which are not entitled to the pod's namespace.
+	// uids and gids which are not entitled are always rejected.
+	//   - "intersect": drops not entitled gids from (supplementalGroups ∪ fsGroup)
+	//   - "reject": rejects the container
+	EntitlementPolicy EntitlementPolicy `toml:"entitlement-policy" default:"intersect"`
+
+	// PodLookup is configuration for timeouts and retries of getting pods from kubelet
+	PodLookup PodLookupConfig `toml:"pod-lookup"`
+
+	// PodCache is configuration for the node-local cache of pods shared by runtime invocations
+	PodCache PodCacheConfig `toml:"pod-cache"`
+
+	// GroupDatabase is configuration for allowing gids based on the host's group database
+	GroupDatabase GroupDatabaseConfig `toml:"group-database"`
+
+	// Hardening is configuration for preventing enforced containers from regaining dropped groups
+	Hardening HardeningConfig `toml:"hardening"`
+
+	// Capabilities is configuration for capabilities which allow processes to regain dropped groups
+	Capabilities CapabilitiesConfig `toml:"capabilities"`
+
+	// Policy is configuration for per-namespace enforcement actions and exemptions
+	Policy PolicyConfig `toml:"policy"`
+
 	// Logging is configuration for logging
 	Logging LogConfig `toml:"logging"`
+
+	// below fields are filled when loading
+	ConfigProfile          string               `toml:"-"`
+	ResolvedRuntimeProfile RuntimeProfileConfig `toml:"-"`
+}
+
+type PodLookupConfig struct {
+	// RequestTimeout is the timeout of each request to kubelet
+	RequestTimeout time.Duration `toml:"request-timeout" default:"10s"`
+
+	// Deadline is the overall deadline of getting the pod including retries
+	Deadline time.Duration `toml:"deadline" default:"30s"`
+
+	// Retries is the maximum number of retries.  Only the pod (or the container) not found yet and
+	// transient network errors are retried.
+	Retries int `toml:"retries" default:"5"`
+
+	// Backoff is the wait before the first retry.  It doubles on each retry.
+	Backoff time.Duration `toml:"backoff" default:"200ms"`
+}
+
+type PodCacheConfig struct {
+	// File is the path to the cache file of pods got from kubelet.  Concurrent invocations share a single
+	// request to kubelet through it.  Pods are got from kubelet on every invocation when it is empty.
+	File string `toml:"file" default:"/run/strict-supplementalgroups-container-runtime/pods.json"`
+
+	// TTL is how long pods in File are used.  Pods are got from kubelet again when the pod is not in File.
+	TTL time.Duration `toml:"ttl" default:"30s"`
+}
+
+type GroupDatabaseConfig struct {
+	// Mode is how gids which the container's uid belongs to in the group database are used.
+	//   - "disabled": the group database is not used
+	//   - "intersect": allows gids in (supplementalGroups ∪ fsGroup) ∩ (gids the uid belongs to)
+	//   - "replace": allows gids the uid belongs to instead of (supplementalGroups ∪ fsGroup)
+	Mode GroupDatabaseMode `toml:"mode" default:"disabled"`
+
+	// PasswdFile is the path to passwd(5) formatted file to resolve the user name and the primary gid of the uid
+	PasswdFile string `toml:"passwd-file" default:"/etc/passwd"`
+
+	// GroupFile is the path to group(5) formatted file to resolve the groups which the user belongs to
+	GroupFile string `toml:"group-file" default:"/etc/group"`
+}
+
+type HardeningConfig struct {
+	// NoNewPrivileges sets process.noNewPrivileges=true so that setuid/setgid binaries in the container
+	// (e.g. a setgid binary owned by a dropped gid, newgrp(1) and sg(1)) can not regain dropped groups.
+	NoNewPrivileges bool `toml:"no-new-privileges" default:"false"`
+
+	// NosuidHostPaths is the list of host paths(e.g. /mnt/hostpath). Bind mounts whose source is under them are mounted with nosuid.
+	// The root filesystem can not be mounted with nosuid because OCI runtime spec has no mount options for it.
+	// Use NoNewPrivileges to neutralize setuid/setgid binaries in the root filesystem.
+	NosuidHostPaths []string `toml:"nosuid-host-paths"`
+
+	// Seccomp merges rules into linux.seccomp which deny setgroups(2) and allow setgid(2), setregid(2) and setresgid(2)
+	// only for gids the enforced process has (its gid and additionalGids after enforcement, not all the gids the pod is
+	// allowed to have).  A profile is created for Unconfined pods.  The profile is only tightened:
+	// rules for other syscalls and rules not allowing the syscalls are kept intact.  Containers are rejected when
+	// allowing the gids needs too many rules in a profile denying syscalls by default.
+	// This also sets process.noNewPrivileges=true (and "--no-new-privs" of exec) as NoNewPrivileges does, because
+	// runc installs the seccomp filter before setting up the user without it and its own setgroups(2) would be denied.
+	Seccomp bool `toml:"seccomp" default:"false"`
+}
+
+type CapabilitiesConfig struct {
+	// Policy is the policy for enforced containers having Capabilities in any of
+	// process.capabilities.{bounding,effective,permitted,inheritable,ambient}.
+	//   - "ignore": keeps the capabilities
+	//   - "drop": drops the capabilities
+	//   - "reject": rejects the container
+	Policy CapabilitiesPolicy `toml:"policy" default:"ignore"`
+
+	// Capabilities is the list of capabilities which allow processes to regain dropped groups (e.g. by setgroups(2))
+	Capabilities []string `toml:"capabilities" default:"[CAP_SETGID,CAP_SETUID,CAP_SETFCAP,CAP_SYS_ADMIN]"`
+
+	// ExemptNamespaces is the list of glob patterns of namespaces whose pods keep the capabilities
+	ExemptNamespaces []string `toml:"exempt-namespaces"`
+}
+
+type PolicyConfig struct {
+	// DefaultAction is the enforcement action for namespaces matching none of Namespaces.
+	// EnforcementAction is used when it is empty.
+	DefaultAction EnforcementAction `toml:"default-action"`
+
+	// Namespaces is the list of enforcement actions per namespace.  The first matching one is used.
+	Namespaces []NamespacePolicy `toml:"namespaces"`
+
+	// Exemptions is the list of rules for containers which are not enforced at all.
+	Exemptions []Exemption `toml:"exemptions"`
 }
 
+type NamespacePolicy struct {
+	// Namespace is the glob pattern(e.g. "kube-*") of pod's namespace
+	Namespace string `toml:"namespace"`
+
+	// Action is the enforcement action for pods in the matched namespaces
+	Action EnforcementAction `toml:"action"`
+}
+
+// Exemption matches containers by glob patterns.  Empty pattern matches any.
+type Exemption struct {
+	// Namespace is the glob pattern of pod's namespace
+	Namespace string `toml:"namespace"`
+
+	// Pod is the glob pattern of pod's name
+	Pod string `toml:"pod"`
+
+	// Container is the glob pattern of container's name
+	Container string `toml:"container"`
+}
+
+// EnforcementAction is the action taken for violations
+type EnforcementAction string
+
+const (
+	EnforcementActionDrop  EnforcementAction = "drop"
+	EnforcementActionDeny  EnforcementAction = "deny"
+	EnforcementActionAudit EnforcementAction = "audit"
+)
+
+// PrimaryGroupPolicy is the policy for process.user.gid when runAsGroup is not set
+type PrimaryGroupPolicy string
+
+const (
+	PrimaryGroupPolicyIgnore   PrimaryGroupPolicy = "ignore"
+	PrimaryGroupPolicyForce    PrimaryGroupPolicy = "force"
+	PrimaryGroupPolicyRestrict PrimaryGroupPolicy = "restrict"
+	PrimaryGroupPolicyReject   PrimaryGroupPolicy = "reject"
+)
+
+// MergeSupplementalGroupsPolicy is the policy for pods with supplementalGroupsPolicy=Merge
+type MergeSupplementalGroupsPolicy string
+
+const (
+	MergeSupplementalGroupsPolicyOverride MergeSupplementalGroupsPolicy = "override"
+	MergeSupplementalGroupsPolicyRespect  MergeSupplementalGroupsPolicy = "respect"
+)
+
+// CapabilitiesPolicy is the policy for capabilities which allow processes to regain dropped groups
+type CapabilitiesPolicy string
+
+const (
+	CapabilitiesPolicyIgnore CapabilitiesPolicy = "ignore"
+	CapabilitiesPolicyDrop   CapabilitiesPolicy = "drop"
+	CapabilitiesPolicyReject CapabilitiesPolicy = "reject"
+)
+
+// EntitlementPolicy is the policy for supplementalGroups and fsGroup which are not entitled
+type EntitlementPolicy string
+
+const (
+	EntitlementPolicyIntersect EntitlementPolicy = "intersect"
+	EntitlementPolicyReject    EntitlementPolicy = "reject"
+)
+
+// GroupDatabaseMode is how the group database is used
+type GroupDatabaseMode string
+
+const (
+	GroupDatabaseModeDisabled  GroupDatabaseMode = "disabled"
+	GroupDatabaseModeIntersect GroupDatabaseMode = "intersect"
+	GroupDatabaseModeReplace   GroupDatabaseMode = "replace"
+)
+
+// RunAsUserPolicy is the policy for process.user.uid mismatching runAsUser
+type RunAsUserPolicy string
+
+const (
+	RunAsUserPolicyRewrite RunAsUserPolicy = "rewrite"
+	RunAsUserPolicyReject  RunAsUserPolicy = "reject"
+)
+
 type LogConfig struct {
 	// LogFile is the file path to strict-supplementalgroups-container-runtime's log
 	LogFile string `toml:"log-file" defaults:"/dev/null"`
@@ -0,0 +1,92 @@
+package entitlement
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Entitlements is the data structure for the entitlement file which maps namespaces to
+// uids/gids/supplementalGroups which pods in the namespaces are allowed to use.
+//
+//	[[namespaces]]
+//	namespace = "user-alice"
+//	uids = [{ min = 1000, max = 1000 }]
+//	gids = [{ min = 1000, max = 1000 }]
+//	supplemental-groups = [{ min = 60000, max = 60000 }]
+type Entitlements struct {
+	// Namespaces is the list of entitlements per namespace.  The first matching one is used.
+	Namespaces []Namespace `toml:"namespaces"`
+}
+
+type Namespace struct {
+	// Namespace is the glob pattern(e.g. "user-*") of pod's namespace
+	Namespace string `toml:"namespace"`
+
+	// Uids is the allowed ranges of process.user.uid
+	Uids IdRanges `toml:"uids"`
+
+	// Gids is the allowed ranges of process.user.gid
+	Gids IdRanges `toml:"gids"`
+
+	// SupplementalGroups is the allowed ranges of supplementalGroups and fsGroup
+	SupplementalGroups IdRanges `toml:"supplemental-groups"`
+}
+
+// IdRange is the inclusive range of ids
+type IdRange struct {
+	Min int64 `toml:"min"`
+	Max int64 `toml:"max"`
+}
+
+type IdRanges []IdRange
+
+func (rs IdRanges) Contains(id int64) bool {
+	for _, r := range rs {
+		if r.Min <= id && id <= r.Max {
+			return true
+		}
+	}
+	return false
+}
+
+func Load(filePath string) (*Entitlements, error) {
+	entitlementsBytes, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read entitlement file %s: %v", filePath, err)
+	}
+
+	var entitlements Entitlements
+	if err := toml.Unmarshal(entitlementsBytes, &entitlements); err != nil {
+		return nil, fmt.Errorf("Failed to parse entitlement file %s: %v", filePath, err)
+	}
+
+	for i, ns := range entitlements.Namespaces {
+		if _, err := path.Match(ns.Namespace, ""); err != nil || ns.Namespace == "" {
+			return nil, fmt.Errorf("namespaces[%d].namespace: invalid pattern '%s' in entitlement file %s", i, ns.Namespace, filePath)
+		}
+		for _, rs := range []IdRanges{ns.Uids, ns.Gids, ns.SupplementalGroups} {
+			for _, r := range rs {
+				if r.Min > r.Max {
+					return nil, fmt.Errorf("namespaces[%d]: min %d is greater than max %d in entitlement file %s", i, r.Min, r.Max, filePath)
+				}
+			}
+		}
+	}
+
+	return &entitlements, nil
+}
+
+// Find returns the first entitlement matching the namespace, or nil if not found.
+func (e *Entitlements) Find(namespace string) *Namespace {
+	for i := range e.Namespaces {
+		ns := &e.Namespaces[i]
+		// patterns are validated when loading
+		if matched, _ := path.Match(ns.Namespace, namespace); matched {
+			return ns
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package entitlement
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Entitlements", func() {
+	It("loads the entitlement file and finds the namespace", func() {
+		entitlements, err := Load("testdata/entitlements.toml")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entitlements.Namespaces).To(HaveLen(2))
+
+		alice := entitlements.Find("user-alice")
+		Expect(alice).NotTo(BeNil())
+		Expect(alice.Uids.Contains(1000)).To(BeTrue())
+		Expect(alice.Uids.Contains(1001)).To(BeFalse())
+		Expect(alice.SupplementalGroups.Contains(60000)).To(BeTrue())
+		Expect(alice.SupplementalGroups.Contains(61500)).To(BeTrue())
+		Expect(alice.SupplementalGroups.Contains(50000)).To(BeFalse())
+
+		team := entitlements.Find("team-foo")
+		Expect(team).To(BeIdenticalTo(&entitlements.Namespaces[1]))
+
+		Expect(entitlements.Find("user-bob")).To(BeNil())
+	})
+
+	It("fails for invalid range", func() {
+		filePath := filepath.Join(GinkgoT().TempDir(), "entitlements.toml")
+		Expect(os.WriteFile(filePath, []byte(`
+[[namespaces]]
+namespace = "user-alice"
+uids = [{ min = 1001, max = 1000 }]
+`), 0644)).To(Succeed())
+		_, err := Load(filePath)
+		Expect(err).To(HaveOccurred())
+	})
+})
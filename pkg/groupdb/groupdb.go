@@ -0,0 +1,106 @@
+package groupdb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// LookupGroups returns gids which the uid belongs to in the passwd(5) and group(5) formatted files.
+// It includes the primary gid in the passwd file and gids of the groups listing the user as a member in the group file.
+func LookupGroups(passwdFile, groupFile string, uid int64) ([]int64, error) {
+	userName, primaryGid, err := lookupUser(passwdFile, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	gids := []int64{primaryGid}
+	if err := forEachEntry(groupFile, func(fields []string) error {
+		// group_name:password:GID:user_list
+		if len(fields) < 4 {
+			return fmt.Errorf("invalid group entry: %s", strings.Join(fields, ":"))
+		}
+		gid, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid gid in group entry %s: %v", strings.Join(fields, ":"), err)
+		}
+		if gid == primaryGid {
+			return nil
+		}
+		for _, member := range strings.Split(fields[3], ",") {
+			if member == userName {
+				gids = append(gids, gid)
+				break
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("Failed to read group file %s: %v", groupFile, err)
+	}
+
+	return gids, nil
+}
+
+func lookupUser(passwdFile string, uid int64) (string, int64, error) {
+	var userName string
+	var primaryGid int64
+	found := false
+	if err := forEachEntry(passwdFile, func(fields []string) error {
+		if found {
+			return nil
+		}
+		// login_name:password:UID:GID:GECOS:home:shell
+		if len(fields) < 4 {
+			return fmt.Errorf("invalid passwd entry: %s", strings.Join(fields, ":"))
+		}
+		entryUid, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid uid in passwd entry %s: %v", strings.Join(fields, ":"), err)
+		}
+		if entryUid != uid {
+			return nil
+		}
+		primaryGid, err = strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid gid in passwd entry %s: %v", strings.Join(fields, ":"), err)
+		}
+		userName = fields[0]
+		found = true
+		return nil
+	}); err != nil {
+		return "", 0, fmt.Errorf("Failed to read passwd file %s: %v", passwdFile, err)
+	}
+	if !found {
+		return "", 0, fmt.Errorf("uid %d not found in passwd file %s", uid, passwdFile)
+	}
+	return userName, primaryGid, nil
+}
+
+// forEachEntry calls f with colon separated fields for each entry.  Empty lines, comments and NIS entries are skipped.
+func forEachEntry(filePath string, f func(fields []string) error) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// lines are not limited in length because group entries synced from e.g. LDAP can have very long member lists
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if entry := strings.TrimSpace(line); entry != "" && !strings.HasPrefix(entry, "#") && !strings.HasPrefix(entry, "+") && !strings.HasPrefix(entry, "-") {
+			if err := f(strings.Split(entry, ":")); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,13 @@
+package groupdb
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestGroupdb(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Groupdb Suite")
+}
@@ -0,0 +1,43 @@
+package groupdb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LookupGroups", func() {
+	DescribeTable("lookup",
+		func(uid int64, expectedGids []int64) {
+			gids, err := LookupGroups("testdata/passwd", "testdata/group", uid)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(gids).To(ConsistOf(expectedGids))
+		},
+		Entry("root", int64(0), []int64{0}),
+		Entry("alice", int64(1000), []int64{1000, 60000}),
+		Entry("bob", int64(1001), []int64{1001, 60000, 60001}),
+	)
+
+	It("fails for unknown uid", func() {
+		_, err := LookupGroups("testdata/passwd", "testdata/group", 2000)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("reads group entries longer than 64KB", func() {
+		members := []string{}
+		for i := 0; i < 20000; i++ {
+			members = append(members, fmt.Sprintf("user%05d", i))
+		}
+		members = append(members, "alice")
+		groupFile := filepath.Join(GinkgoT().TempDir(), "group")
+		Expect(os.WriteFile(groupFile, []byte("alice:x:1000:\nldap-users:x:70000:"+strings.Join(members, ",")+"\n"), 0644)).To(Succeed())
+
+		gids, err := LookupGroups("testdata/passwd", groupFile, 1000)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(gids).To(ConsistOf(int64(1000), int64(70000)))
+	})
+})
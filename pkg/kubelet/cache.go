@@ -0,0 +1,164 @@
+package kubelet
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog"
+	zlog "github.com/rs/zerolog/log"
+
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/atomicfile"
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/config"
+)
+
+// PodCache is the node-local cache file of pods shared by runtime invocations.  The cache is refreshed
+// under flock(2) on the lock file so that concurrent invocations missing the cache share a single request
+// to kubelet.  The cache file is replaced atomically so that it can be read without the lock.
+type PodCache struct {
+	file   string
+	ttl    time.Duration
+	now    func() time.Time
+	logger zerolog.Logger
+}
+
+// podCacheData is the content of the cache file
+type podCacheData struct {
+	FetchedAt time.Time `json:"fetchedAt"`
+	// Pods is keyed by pod's uid.  Pods have only the fields used for enforcement (see Pod.SecuritySubset).
+	Pods map[string]*Pod `json:"pods"`
+}
+
+func NewPodCache(cfg *config.Config) *PodCache {
+	return &PodCache{
+		file:   cfg.PodCache.File,
+		ttl:    cfg.PodCache.TTL,
+		now:    time.Now,
+		logger: zlog.With().Str("PodCache", cfg.PodCache.File).Logger(),
+	}
+}
+
+// Pod returns the pod with the namespace/name/uid.  When the pod having the container(if not empty) is not
+// in the cache or the cache is expired, the cache is refreshed with all pods returned by listPods.
+func (c *PodCache) Pod(namespace, name, uid, containerName string, listPods func() ([]*Pod, error)) (*Pod, error) {
+	if pod := c.lookup(namespace, name, uid, containerName); pod != nil {
+		c.logger.Debug().Msg("Pod found in the cache")
+		return pod, nil
+	}
+
+	unlock, err := c.lock()
+	if err != nil {
+		// the cache is only an optimization
+		c.logger.Warn().Err(err).Msg("Failed to lock the pod cache. Get the pod without the cache")
+		pods, err := listPods()
+		if err != nil {
+			return nil, err
+		}
+		return findPod(pods, namespace, name, uid)
+	}
+	defer unlock()
+
+	// another invocation may have refreshed the cache while waiting for the lock
+	if pod := c.lookup(namespace, name, uid, containerName); pod != nil {
+		c.logger.Debug().Msg("Pod found in the cache refreshed by another invocation")
+		return pod, nil
+	}
+
+	fetchedAt := c.now()
+	pods, err := listPods()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.write(fetchedAt, pods); err != nil {
+		c.logger.Warn().Err(err).Msg("Failed to write the pod cache. Ignored.")
+	} else {
+		c.logger.Debug().Int("Pods", len(pods)).Msg("Pod cache refreshed")
+	}
+	return findPod(pods, namespace, name, uid)
+}
+
+// lookup returns the pod in the cache.  It returns nil when the cache is not available, expired or
+// the pod having the container is not in the cache.
+func (c *PodCache) lookup(namespace, name, uid, containerName string) *Pod {
+	dataRaw, err := os.ReadFile(c.file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			c.logger.Debug().Err(err).Msg("Failed to read the pod cache")
+		}
+		return nil
+	}
+	var data podCacheData
+	if err := json.Unmarshal(dataRaw, &data); err != nil {
+		c.logger.Debug().Err(err).Msg("Failed to parse the pod cache")
+		return nil
+	}
+	if age := c.now().Sub(data.FetchedAt); age < 0 || age >= c.ttl {
+		return nil
+	}
+	pod, ok := data.Pods[uid]
+	if !ok || pod == nil || pod.Namespace != namespace || pod.Name != name {
+		return nil
+	}
+	// ephemeral containers can be added to the cached pod
+	if containerName != "" && !pod.HasContainer(containerName) {
+		return nil
+	}
+	return pod
+}
+
+// lock locks the lock file next to the cache file exclusively and returns the function to unlock it
+func (c *PodCache) lock() (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(c.file), 0700); err != nil {
+		return nil, fmt.Errorf("Failed to create pod cache directory: %v", err)
+	}
+	lockFile, err := os.OpenFile(c.file+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open pod cache lock file: %v", err)
+	}
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		lockFile.Close()
+		return nil, fmt.Errorf("Failed to lock pod cache lock file: %v", err)
+	}
+	return func() {
+		// closing the file releases the lock
+		lockFile.Close()
+	}, nil
+}
+
+func (c *PodCache) write(fetchedAt time.Time, pods []*Pod) error {
+	data := podCacheData{FetchedAt: fetchedAt, Pods: map[string]*Pod{}}
+	for _, pod := range pods {
+		data.Pods[string(pod.UID)] = pod.SecuritySubset()
+	}
+	dataRaw, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal pod cache: %v", err)
+	}
+
+	if err := atomicfile.WriteFile(c.file, dataRaw); err != nil {
+		return fmt.Errorf("Failed to write pod cache file: %v", err)
+	}
+	return nil
+}
+
+// findPod returns the pod with the namespace/name/uid in pods.  The pod recreated with the same name can
+// coexist with the old one, so the uid mismatch is returned only when no pod has the uid.
+func findPod(pods []*Pod, namespace, name, uid string) (*Pod, error) {
+	var mismatched *Pod
+	for _, pod := range pods {
+		if pod.Namespace != namespace || pod.Name != name {
+			continue
+		}
+		if string(pod.UID) == uid {
+			return pod, nil
+		}
+		mismatched = pod
+	}
+	if mismatched != nil {
+		return nil, podUIDMismatchError(namespace, name, string(mismatched.UID), uid)
+	}
+	return nil, &NotFoundError{Namespace: namespace, Name: name}
+}
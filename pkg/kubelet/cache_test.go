@@ -0,0 +1,177 @@
+package kubelet
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/rs/zerolog"
+	corev1 "k8s.io/api/core/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("PodCache", func() {
+	newPod := func(namespace, name, uid string, containers ...string) *Pod {
+		pod := &Pod{}
+		pod.Namespace, pod.Name, pod.UID = namespace, name, k8stypes.UID("uid-"+uid)
+		for _, c := range containers {
+			pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: c, Image: "busybox"})
+		}
+		return pod
+	}
+
+	var (
+		c      *PodCache
+		now    time.Time
+		pods   []*Pod
+		listed int32
+	)
+	listPods := func() ([]*Pod, error) {
+		atomic.AddInt32(&listed, 1)
+		return pods, nil
+	}
+	BeforeEach(func() {
+		now = time.Date(2022, 8, 1, 0, 0, 0, 0, time.UTC)
+		c = &PodCache{
+			file:   filepath.Join(GinkgoT().TempDir(), "cache", "pods.json"),
+			ttl:    30 * time.Second,
+			now:    func() time.Time { return now },
+			logger: zerolog.Nop(),
+		}
+		pods = []*Pod{newPod("user-alice", "pod", "1", "main"), newPod("user-bob", "pod", "2", "main")}
+		listed = 0
+	})
+
+	It("is refreshed on a miss and shared until it expires", func() {
+		pod, err := c.Pod("user-alice", "pod", "uid-1", "main", listPods)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(pod.UID)).To(Equal("uid-1"))
+		Expect(listed).To(BeEquivalentTo(1))
+
+		// other pods in the cache are shared
+		now = now.Add(29 * time.Second)
+		pod, err = c.Pod("user-bob", "pod", "uid-2", "main", listPods)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(pod.UID)).To(Equal("uid-2"))
+		Expect(pod.Spec.Containers[0].Image).To(BeEmpty())
+		Expect(listed).To(BeEquivalentTo(1))
+
+		now = now.Add(time.Second)
+		_, err = c.Pod("user-bob", "pod", "uid-2", "main", listPods)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(listed).To(BeEquivalentTo(2))
+	})
+
+	It("is refreshed for the pod recreated with the same name", func() {
+		_, err := c.Pod("user-alice", "pod", "uid-1", "main", listPods)
+		Expect(err).NotTo(HaveOccurred())
+
+		pods = []*Pod{newPod("user-alice", "pod", "3", "main")}
+		pod, err := c.Pod("user-alice", "pod", "uid-3", "main", listPods)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(pod.UID)).To(Equal("uid-3"))
+		Expect(listed).To(BeEquivalentTo(2))
+
+		_, err = c.Pod("user-alice", "pod", "uid-1", "main", listPods)
+		Expect(err).To(MatchError(ContainSubstring("Pod UID mismatch")))
+		Expect(listed).To(BeEquivalentTo(3))
+	})
+
+	It("finds the pod by uid while the old pod with the same name is terminating", func() {
+		pods = []*Pod{newPod("user-alice", "pod", "1", "main"), newPod("user-alice", "pod", "3", "main")}
+		pod, err := c.Pod("user-alice", "pod", "uid-3", "main", listPods)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(pod.UID)).To(Equal("uid-3"))
+
+		// without the cache
+		pod, err = findPod(pods, "user-alice", "pod", "uid-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(pod.UID)).To(Equal("uid-1"))
+		_, err = findPod(pods, "user-alice", "pod", "uid-4")
+		Expect(err).To(MatchError(ContainSubstring("Pod UID mismatch")))
+	})
+
+	It("is refreshed for the container added to the pod", func() {
+		_, err := c.Pod("user-alice", "pod", "uid-1", "main", listPods)
+		Expect(err).NotTo(HaveOccurred())
+
+		pods = []*Pod{newPod("user-alice", "pod", "1", "main", "debugger")}
+		pod, err := c.Pod("user-alice", "pod", "uid-1", "debugger", listPods)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pod.HasContainer("debugger")).To(BeTrue())
+		Expect(listed).To(BeEquivalentTo(2))
+	})
+
+	It("fails when the pod is not found", func() {
+		_, err := c.Pod("user-alice", "missing", "uid-4", "main", listPods)
+		Expect(err).To(MatchError("Pod not found: user-alice/missing"))
+	})
+
+	It("shares a single refresh between concurrent invocations", func() {
+		slowListPods := func() ([]*Pod, error) {
+			time.Sleep(100 * time.Millisecond)
+			return listPods()
+		}
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				_, err := c.Pod("user-alice", "pod", "uid-1", "main", slowListPods)
+				Expect(err).NotTo(HaveOccurred())
+			}()
+		}
+		wg.Wait()
+		Expect(listed).To(BeEquivalentTo(1))
+	})
+})
+
+// BenchmarkPodCache measures getting a pod with the pod cache enabled (the default).  On a miss, all pods are
+// decoded from kubelet's response and written to the cache.
+func BenchmarkPodCache(b *testing.B) {
+	list := loadBenchmarkPodList(b)
+	client := newBenchmarkClient(b, list)
+	listPods := func() ([]*Pod, error) {
+		return client.Pods(context.Background())
+	}
+	c := &PodCache{
+		file:   filepath.Join(b.TempDir(), "pods.json"),
+		ttl:    time.Hour,
+		now:    time.Now,
+		logger: zerolog.Nop(),
+	}
+
+	for i, pod := range list.targets {
+		pod := pod
+		b.Run(fmt.Sprintf("miss/%d-%s/%dKiB", i, pod.Name, len(list.raw)/1024), func(b *testing.B) {
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				b.StopTimer()
+				if err := os.Remove(c.file); err != nil && !os.IsNotExist(err) {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+				if _, err := c.Pod(pod.Namespace, pod.Name, string(pod.UID), pod.Spec.Containers[0].Name, listPods); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("hit/%d-%s", i, pod.Name), func(b *testing.B) {
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				if _, err := c.Pod(pod.Namespace, pod.Name, string(pod.UID), pod.Spec.Containers[0].Name, listPods); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
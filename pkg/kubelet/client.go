@@ -1,32 +1,39 @@
 package kubelet
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
-	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/rs/zerolog"
 	zlog "github.com/rs/zerolog/log"
 
-	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 
 	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/config"
 )
 
+const (
+	// statusErrorBodySize is the maximum size of the response body in StatusError
+	statusErrorBodySize = 1024
+)
+
 type Client struct {
-	kubeletUrl url.URL
-	restConfig *rest.Config
-	httpClient *http.Client
-	logger     zerolog.Logger
+	kubeletUrl      url.URL
+	restConfig      *rest.Config
+	httpClient      *http.Client
+	maxResponseSize int64
+	logger          zerolog.Logger
 }
 
 func NewKubeletClient(
@@ -37,38 +44,15 @@ func NewKubeletClient(
 		return nil, fmt.Errorf("Failed to read kubeconfg %s: %v", cfg.KubeConfig, err)
 	}
 
-	var cert tls.Certificate
-	if restConfig.TLSClientConfig.CertFile != "" {
-		cert, err = tls.LoadX509KeyPair(restConfig.TLSClientConfig.CertFile, restConfig.TLSClientConfig.KeyFile)
-	} else {
-		cert, err = tls.X509KeyPair(restConfig.TLSClientConfig.CertData, restConfig.TLSClientConfig.KeyData)
-	}
+	tlsConfig, err := newTLSConfig(cfg, restConfig)
 	if err != nil {
 		return nil, err
 	}
-
-	var caCert []byte
-	if restConfig.TLSClientConfig.CAFile != "" {
-		caCert, err = os.ReadFile(restConfig.TLSClientConfig.CAFile)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		caCert = restConfig.TLSClientConfig.CAData
-	}
-
-	caCertPool := x509.NewCertPool()
-	caCertPool.AppendCertsFromPEM(caCert)
-
 	tr := &http.Transport{
 		MaxIdleConns:       10,
 		IdleConnTimeout:    30 * time.Second,
 		DisableCompression: true,
-		TLSClientConfig: &tls.Config{
-			Certificates:       []tls.Certificate{cert},
-			RootCAs:            caCertPool,
-			InsecureSkipVerify: true,
-		},
+		TLSClientConfig:    tlsConfig,
 	}
 
 	url, err := url.Parse(cfg.KubeletUrl)
@@ -81,40 +65,190 @@ func NewKubeletClient(
 		restConfig: restConfig,
 		httpClient: &http.Client{
 			Transport: tr,
-			Timeout:   time.Second * 20,
+			Timeout:   cfg.PodLookup.RequestTimeout,
 		},
-		logger: zlog.With().Str("Kubelet", url.String()).Logger(),
+		maxResponseSize: cfg.KubeletMaxResponseSize,
+		logger:          zlog.With().Str("Kubelet", url.String()).Logger(),
 	}, nil
 }
 
-func (c *Client) Pod(namespace, name string) (*corev1.Pod, error) {
-	url := c.kubeletUrl
-	url.Path = path.Join(url.Path, "pods")
-	req, _ := http.NewRequest("GET", url.String(), nil)
+// hostname returns the node's hostname.  It is replaced in tests.
+var hostname = os.Hostname
 
-	c.logger.Trace().Msg("Running 'GET /pods'")
-	resp, err := (*c.httpClient).Do(req)
+// newTLSConfig returns TLS config with the client certificate in kubeconfig which verifies kubelet's serving
+// certificate with the CA in kubeconfig and kubelet-ca-file against kubelet-server-name (see kubeletServerName).
+func newTLSConfig(cfg *config.Config, restConfig *rest.Config) (*tls.Config, error) {
+	var cert tls.Certificate
+	var err error
+	if restConfig.TLSClientConfig.CertFile != "" {
+		cert, err = tls.LoadX509KeyPair(restConfig.TLSClientConfig.CertFile, restConfig.TLSClientConfig.KeyFile)
+	} else {
+		cert, err = tls.X509KeyPair(restConfig.TLSClientConfig.CertData, restConfig.TLSClientConfig.KeyData)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("Failed to run HTTP request: %v", err)
+		return nil, fmt.Errorf("Failed to load client certificate in kubeconfig %s: %v", cfg.KubeConfig, err)
+	}
+
+	if cfg.KubeletInsecureSkipTLSVerify {
+		return &tls.Config{
+			Certificates:       []tls.Certificate{cert},
+			InsecureSkipVerify: true,
+		}, nil
 	}
 
-	bodyBytes, err := io.ReadAll(resp.Body)
+	caCertPool := x509.NewCertPool()
+	caSources := []string{}
+
+	// the cluster CA signs serving certificates of kubelets with serverTLSBootstrap
+	clusterCACert := restConfig.TLSClientConfig.CAData
+	if restConfig.TLSClientConfig.CAFile != "" {
+		clusterCACert, err = os.ReadFile(restConfig.TLSClientConfig.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read CA bundle to verify kubelet: %v", err)
+		}
+	}
+	if caCertPool.AppendCertsFromPEM(clusterCACert) {
+		caSources = append(caSources, "kubeconfig "+cfg.KubeConfig)
+	}
+
+	// kubelets without serverTLSBootstrap use the self-signed serving certificate
+	if cfg.KubeletCAFile != "" {
+		caCert, err := os.ReadFile(cfg.KubeletCAFile)
+		switch {
+		case os.IsNotExist(err):
+			zlog.Debug().Str("KubeletCAFile", cfg.KubeletCAFile).Msg("kubelet-ca-file does not exist. Ignored")
+		case err != nil:
+			return nil, fmt.Errorf("Failed to read CA bundle to verify kubelet: %v", err)
+		case !caCertPool.AppendCertsFromPEM(caCert):
+			return nil, fmt.Errorf("No CA certificate to verify kubelet found in %s", cfg.KubeletCAFile)
+		default:
+			caSources = append(caSources, cfg.KubeletCAFile)
+		}
+	}
+	if len(caSources) == 0 {
+		return nil, fmt.Errorf("No CA certificate to verify kubelet found in kubeconfig %s or kubelet-ca-file", cfg.KubeConfig)
+	}
+
+	serverName, err := kubeletServerName(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to read HTTP response body: %v", err)
+		return nil, err
 	}
-	c.logger.Trace().Bytes("Body", bodyBytes).Msg("Read HTTP response body succeeded")
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      caCertPool,
+		ServerName:   serverName,
+	}, nil
+}
 
-	var pods corev1.PodList
-	err = json.Unmarshal(bodyBytes, &pods)
+// kubeletServerName returns the expected server name in kubelet's serving certificate.  It is kubelet-server-name if set.
+// Otherwise, it is the node's hostname for loopback kubelet-url (e.g. https://127.0.0.1:10250) because kubelet's serving
+// certificate is valid for the hostname but not for loopback addresses, or the host in kubelet-url.
+func kubeletServerName(cfg *config.Config) (string, error) {
+	if cfg.KubeletServerName != "" {
+		return cfg.KubeletServerName, nil
+	}
+	u, err := url.Parse(cfg.KubeletUrl)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to unmarshal HTTP response body to v1.PodList: %v", err)
+		return "", fmt.Errorf("Failed to parse kubeletUrl: %v", err)
 	}
-	c.logger.Trace().Interface("PodList", pods).Msg("Marshal HTTP response body succeeded")
+	host := u.Hostname()
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return host, nil
+	}
+	name, err := hostname()
+	if err != nil {
+		return "", fmt.Errorf("Failed to get hostname to verify kubelet: %v", err)
+	}
+	// kubelet lowercases the hostname
+	return strings.ToLower(strings.TrimSpace(name)), nil
+}
 
-	for _, pod := range pods.Items {
-		if pod.Namespace == namespace && pod.Name == name {
-			return &pod, nil
+// Pod returns the pod with the namespace/name/uid.  The uid is needed because the pod can be recreated with
+// the same namespace/name (e.g. StatefulSet).  The uid mismatch is returned only when no pod has the uid.
+func (c *Client) Pod(ctx context.Context, namespace, name, uid string) (*Pod, error) {
+	body, err := c.getPods(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	// the pod recreated with the same name can coexist with the old one which is terminating
+	var pod *Pod
+	mismatched, mismatchedUID := false, ""
+	scanned, err := decodePodList(body, func(item *podListItem) (bool, error) {
+		meta, err := item.meta()
+		if err != nil || meta.Namespace != namespace || meta.Name != name {
+			return false, err
+		}
+		if meta.UID != uid {
+			mismatched, mismatchedUID = true, meta.UID
+			return false, nil
 		}
+		pod, err = item.pod()
+		return true, err
+	})
+	if err != nil {
+		return nil, err
 	}
-	return nil, fmt.Errorf("Pod not found: %s/%s", namespace, name)
+	c.logger.Trace().Int("Items", scanned).Msg("Decoding HTTP response body succeeded")
+	if pod == nil {
+		if mismatched {
+			return nil, podUIDMismatchError(namespace, name, mismatchedUID, uid)
+		}
+		return nil, &NotFoundError{Namespace: namespace, Name: name}
+	}
+	c.logger.Trace().Interface("Pod", pod).Msg("Pod found")
+	return pod, nil
+}
+
+// Pods returns all pods in kubelet.  Pods have only the fields used for enforcement (see Pod.SecuritySubset).
+func (c *Client) Pods(ctx context.Context) ([]*Pod, error) {
+	body, err := c.getPods(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	pods := []*Pod{}
+	scanned, err := decodePodList(body, func(item *podListItem) (bool, error) {
+		pod, err := item.pod()
+		if err != nil {
+			return false, err
+		}
+		pods = append(pods, pod.SecuritySubset())
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.logger.Trace().Int("Items", scanned).Msg("Decoding HTTP response body succeeded")
+	return pods, nil
+}
+
+// getPods runs 'GET /pods' and returns the response body limited to the maximum response size
+func (c *Client) getPods(ctx context.Context) (io.ReadCloser, error) {
+	url := c.kubeletUrl
+	url.Path = path.Join(url.Path, "pods")
+	req, _ := http.NewRequestWithContext(ctx, "GET", url.String(), nil)
+
+	c.logger.Trace().Msg("Running 'GET /pods'")
+	resp, err := (*c.httpClient).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to run HTTP request: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, statusErrorBodySize))
+		return nil, &StatusError{StatusCode: resp.StatusCode, Status: resp.Status, Body: string(body)}
+	}
+	return &limitedReadCloser{
+		limitedReader: limitedReader{r: resp.Body, remaining: c.maxResponseSize, limit: c.maxResponseSize},
+		closer:        resp.Body,
+	}, nil
+}
+
+// podUIDMismatchError is the error for the pod with the namespace/name found only with other uids
+func podUIDMismatchError(namespace, name, actualUID, uid string) error {
+	return fmt.Errorf("Pod UID mismatch: %s/%s has uid %q but %q is expected", namespace, name, actualUID, uid)
 }
@@ -0,0 +1,210 @@
+package kubelet
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	testPodList = `{"kind": "PodList", "apiVersion": "v1", "metadata": {}, "items": [
+		{"metadata": {"namespace": "user-alice", "name": "other", "uid": "uid-0"}},
+		{"metadata": {"namespace": "user-alice", "name": "pod", "uid": "uid-1"}, "spec": {"securityContext": {"supplementalGroups": [60000]}}}
+	]}`
+	testMaxResponseSize = 1024 * 1024
+)
+
+// newTestClient returns the client for the kubelet serving the body for /pods with the status
+func newTestClient(status int, body []byte) *Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/pods" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write(body)
+	}))
+	DeferCleanup(server.Close)
+	serverUrl, err := url.Parse(server.URL)
+	Expect(err).NotTo(HaveOccurred())
+	return &Client{kubeletUrl: *serverUrl, httpClient: server.Client(), maxResponseSize: testMaxResponseSize, logger: zerolog.Nop()}
+}
+
+var _ = Describe("Client", func() {
+	DescribeTable("Pod",
+		func(namespace, name, uid string, expectErr bool) {
+			c := newTestClient(http.StatusOK, []byte(testPodList))
+			pod, err := c.Pod(context.Background(), namespace, name, uid)
+			if expectErr {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pod.Namespace).To(Equal(namespace))
+			Expect(pod.Name).To(Equal(name))
+			Expect(string(pod.UID)).To(Equal(uid))
+			Expect(pod.Spec.SecurityContext.SupplementalGroups).To(Equal([]int64{60000}))
+		},
+		Entry("namespace/name/uid match", "user-alice", "pod", "uid-1", false),
+		Entry("uid mismatch (recreated pod)", "user-alice", "pod", "uid-2", true),
+		Entry("uid is empty", "user-alice", "pod", "", true),
+		Entry("not found", "user-alice", "missing", "uid-1", true),
+	)
+
+	DescribeTable("Pod with the old pod having the same name",
+		func(items string, uid string, expectErr string) {
+			c := newTestClient(http.StatusOK, []byte(`{"items": [`+items+`]}`))
+			pod, err := c.Pod(context.Background(), "user-alice", "pod", uid)
+			if expectErr != "" {
+				Expect(err).To(MatchError(ContainSubstring(expectErr)))
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(pod.UID)).To(Equal(uid))
+		},
+		Entry("the old pod comes first",
+			`{"metadata": {"namespace": "user-alice", "name": "pod", "uid": "uid-1"}},
+			 {"metadata": {"namespace": "user-alice", "name": "pod", "uid": "uid-2"}}`,
+			"uid-2", "",
+		),
+		Entry("the new pod comes first",
+			`{"metadata": {"namespace": "user-alice", "name": "pod", "uid": "uid-2"}},
+			 {"metadata": {"namespace": "user-alice", "name": "pod", "uid": "uid-1"}}`,
+			"uid-1", "",
+		),
+		Entry("no pod has the uid",
+			`{"metadata": {"namespace": "user-alice", "name": "pod", "uid": "uid-1"}},
+			 {"metadata": {"namespace": "user-alice", "name": "pod", "uid": "uid-2"}}`,
+			"uid-3", "Pod UID mismatch",
+		),
+	)
+
+	It("returns all pods having only the fields used for enforcement", func() {
+		c := newTestClient(http.StatusOK, []byte(testPodList))
+		pods, err := c.Pods(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pods).To(HaveLen(2))
+		Expect(pods[1].Name).To(Equal("pod"))
+		Expect(pods[1].Spec.SecurityContext.SupplementalGroups).To(Equal([]int64{60000}))
+	})
+
+	It("stops reading the response at the pod", func() {
+		// items after the pod are never decoded
+		body := `{"items": [{"metadata": {"namespace": "user-alice", "name": "pod", "uid": "uid-1"}}, {"broken`
+		c := newTestClient(http.StatusOK, []byte(body))
+		pod, err := c.Pod(context.Background(), "user-alice", "pod", "uid-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pod.Name).To(Equal("pod"))
+	})
+
+	It("fails for a malformed response", func() {
+		c := newTestClient(http.StatusOK, []byte(`{"items": {}}`))
+		_, err := c.Pod(context.Background(), "user-alice", "pod", "uid-1")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("fails with a retryable error when kubelet is not available", func() {
+		c := newTestClient(http.StatusOK, []byte(testPodList))
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+		serverUrl, err := url.Parse(server.URL)
+		Expect(err).NotTo(HaveOccurred())
+		c.kubeletUrl = *serverUrl
+		_, err = c.Pod(context.Background(), "user-alice", "pod", "uid-1")
+		Expect(err).To(HaveOccurred())
+		Expect(IsRetryable(err)).To(BeTrue())
+	})
+
+	It("fails with StatusError for non-200 responses", func() {
+		c := newTestClient(http.StatusUnauthorized, []byte("Unauthorized"))
+		_, err := c.Pod(context.Background(), "user-alice", "pod", "uid-1")
+		var statusErr *StatusError
+		Expect(err).To(BeAssignableToTypeOf(statusErr))
+		statusErr = err.(*StatusError)
+		Expect(statusErr.StatusCode).To(Equal(http.StatusUnauthorized))
+		Expect(statusErr.Body).To(Equal("Unauthorized"))
+	})
+
+	It("fails with ResponseTooLargeError when the response exceeds the maximum size", func() {
+		padding := strings.Repeat(" ", testMaxResponseSize)
+		c := newTestClient(http.StatusOK, []byte(`{"items": [`+padding+`]}`))
+		_, err := c.Pod(context.Background(), "user-alice", "pod", "uid-1")
+		var tooLarge *ResponseTooLargeError
+		Expect(err).To(BeAssignableToTypeOf(tooLarge))
+	})
+
+	It("accepts the response exactly fitting in the maximum size", func() {
+		body := testPodList + strings.Repeat(" ", testMaxResponseSize-len(testPodList))
+		c := newTestClient(http.StatusOK, []byte(body))
+		_, err := c.Pod(context.Background(), "user-alice", "missing", "uid-1")
+		Expect(err).To(MatchError("Pod not found: user-alice/missing"))
+	})
+})
+
+// benchmarkPodList is the PodList in testdata/pods.json and the first, middle and last pod in it
+type benchmarkPodList struct {
+	raw     []byte
+	targets []*Pod
+}
+
+// loadBenchmarkPodList loads testdata/pods.json, the PodList of 110 pods (the default max-pods of kubelet) of
+// daemonsets, deployments, statefulsets and jobs on a node.
+func loadBenchmarkPodList(b *testing.B) *benchmarkPodList {
+	raw, err := os.ReadFile("testdata/pods.json")
+	if err != nil {
+		b.Fatal(err)
+	}
+	var list struct {
+		Items []*Pod `json:"items"`
+	}
+	if err := json.Unmarshal(raw, &list); err != nil {
+		b.Fatal(err)
+	}
+	n := len(list.Items)
+	return &benchmarkPodList{
+		raw:     raw,
+		targets: []*Pod{list.Items[0], list.Items[n/2], list.Items[n-1]},
+	}
+}
+
+// newBenchmarkClient returns the client for the kubelet serving the PodList for /pods
+func newBenchmarkClient(b *testing.B, list *benchmarkPodList) *Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(list.raw)
+	}))
+	b.Cleanup(server.Close)
+	serverUrl, err := url.Parse(server.URL)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return &Client{kubeletUrl: *serverUrl, httpClient: server.Client(), maxResponseSize: 64 * 1024 * 1024, logger: zerolog.Nop()}
+}
+
+// BenchmarkClientPod measures getting a pod from kubelet when the pod cache is disabled
+func BenchmarkClientPod(b *testing.B) {
+	list := loadBenchmarkPodList(b)
+	c := newBenchmarkClient(b, list)
+
+	for i, pod := range list.targets {
+		pod := pod
+		b.Run(fmt.Sprintf("%d-%s/%dKiB", i, pod.Name, len(list.raw)/1024), func(b *testing.B) {
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				if _, err := c.Pod(context.Background(), pod.Namespace, pod.Name, string(pod.UID)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
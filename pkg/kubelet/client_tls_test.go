@@ -0,0 +1,169 @@
+package kubelet
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/config"
+)
+
+// testCert is a certificate and its key for TLS tests
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert creates the certificate signed by parent (self-signed if nil)
+func newTestCert(commonName string, isCA bool, dnsNames []string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		DNSNames:              dnsNames,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	Expect(err).NotTo(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).NotTo(HaveOccurred())
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+	}
+}
+
+var _ = Describe("NewKubeletClient", func() {
+	var (
+		dir        string
+		serverUrl  string
+		kubeconfig string
+	)
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		clusterCA := newTestCert("cluster-ca", true, nil, nil)
+		client := newTestCert("system:node:node-1", false, nil, clusterCA)
+		// kubelet's self-signed serving certificate is valid only for the node's hostname
+		kubeletCA := newTestCert("node-1-ca", true, nil, nil)
+		serving := newTestCert("node-1", false, []string{"node-1"}, kubeletCA)
+
+		servingCert, err := tls.X509KeyPair(serving.certPEM, serving.keyPEM)
+		Expect(err).NotTo(HaveOccurred())
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(testPodList))
+		}))
+		server.TLS = &tls.Config{Certificates: []tls.Certificate{servingCert}}
+		server.StartTLS()
+		DeferCleanup(server.Close)
+		serverUrl = server.URL
+
+		kubeconfig = filepath.Join(dir, "kubelet.conf")
+		Expect(os.WriteFile(kubeconfig, []byte(fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: default
+  cluster:
+    server: https://127.0.0.1:6443
+    certificate-authority-data: %s
+users:
+- name: default
+  user:
+    client-certificate-data: %s
+    client-key-data: %s
+contexts:
+- name: default
+  context:
+    cluster: default
+    user: default
+current-context: default
+`,
+			base64.StdEncoding.EncodeToString(clusterCA.certPEM),
+			base64.StdEncoding.EncodeToString(client.certPEM),
+			base64.StdEncoding.EncodeToString(client.keyPEM),
+		)), 0600)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "kubelet.crt"), append(append([]byte{}, serving.certPEM...), kubeletCA.certPEM...), 0600)).To(Succeed())
+	})
+
+	DescribeTable("verifies kubelet's serving certificate",
+		func(caFile, serverName, nodeHostname string, insecure bool, expectErr bool) {
+			hostname = func() (string, error) { return nodeHostname, nil }
+			DeferCleanup(func() { hostname = os.Hostname })
+
+			c, err := NewKubeletClient(&config.Config{
+				KubeletUrl:                   serverUrl,
+				KubeConfig:                   kubeconfig,
+				KubeletCAFile:                filepath.Join(dir, caFile),
+				KubeletServerName:            serverName,
+				KubeletInsecureSkipTLSVerify: insecure,
+				KubeletMaxResponseSize:       testMaxResponseSize,
+				PodLookup:                    config.PodLookupConfig{RequestTimeout: 10 * time.Second},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = c.Pod(context.Background(), "user-alice", "pod", "uid-1")
+			if expectErr {
+				Expect(err).To(HaveOccurred())
+				Expect(IsRetryable(err)).To(BeFalse())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+		},
+		Entry("with kubelet-ca-file and the node's hostname (default)", "kubelet.crt", "", "Node-1", false, false),
+		Entry("with kubelet-ca-file and the hostname mismatching", "kubelet.crt", "", "node-2", false, true),
+		Entry("with only the CA in kubeconfig (not signing the serving certificate)", "missing.crt", "", "node-1", false, true),
+		Entry("with kubelet-ca-file and kubelet-server-name", "kubelet.crt", "node-1", "node-2", false, false),
+		Entry("with kubelet-ca-file and wrong kubelet-server-name", "kubelet.crt", "node-2", "node-1", false, true),
+		Entry("with kubelet-insecure-skip-tls-verify", "missing.crt", "", "node-2", true, false),
+	)
+
+	DescribeTable("kubeletServerName",
+		func(kubeletUrl, serverName, expected string) {
+			hostname = func() (string, error) { return "node-1", nil }
+			DeferCleanup(func() { hostname = os.Hostname })
+			Expect(kubeletServerName(&config.Config{KubeletUrl: kubeletUrl, KubeletServerName: serverName})).To(Equal(expected))
+		},
+		Entry("loopback address", "https://127.0.0.1:10250", "", "node-1"),
+		Entry("localhost", "https://localhost:10250", "", "node-1"),
+		Entry("IPv6 loopback address", "https://[::1]:10250", "", "node-1"),
+		Entry("other host", "https://node-1.example.com:10250", "", "node-1.example.com"),
+		Entry("kubelet-server-name", "https://127.0.0.1:10250", "node-2", "node-2"),
+	)
+
+	It("fails when kubelet-ca-file has no certificate", func() {
+		caFile := filepath.Join(dir, "empty.crt")
+		Expect(os.WriteFile(caFile, []byte{}, 0600)).To(Succeed())
+		_, err := NewKubeletClient(&config.Config{KubeletUrl: serverUrl, KubeConfig: kubeconfig, KubeletCAFile: caFile})
+		Expect(err).To(MatchError(ContainSubstring("No CA certificate")))
+	})
+})
@@ -0,0 +1,62 @@
+package kubelet
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+)
+
+// NotFoundError is returned when the pod, or the container in the pod, is not found in kubelet (yet)
+type NotFoundError struct {
+	Namespace string
+	Name      string
+	// Container is the name of the container not found in the pod.  It is empty when the pod is not found.
+	Container string
+}
+
+func (e *NotFoundError) Error() string {
+	if e.Container != "" {
+		return fmt.Sprintf("Container %s not found in pod %s/%s", e.Container, e.Namespace, e.Name)
+	}
+	return fmt.Sprintf("Pod not found: %s/%s", e.Namespace, e.Name)
+}
+
+// StatusError is returned when kubelet responds with a non-200 status
+type StatusError struct {
+	StatusCode int
+	Status     string
+	// Body is the beginning of the response body
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("kubelet responded %s: %s", e.Status, e.Body)
+}
+
+// ResponseTooLargeError is returned when the response body exceeds the maximum size
+type ResponseTooLargeError struct {
+	Limit int64
+}
+
+func (e *ResponseTooLargeError) Error() string {
+	return fmt.Sprintf("kubelet response exceeds %d bytes", e.Limit)
+}
+
+// IsRetryable reports whether getting the pod may succeed by retrying: the pod (or the container)
+// is not found yet, or a transient network error occurred.
+func IsRetryable(err error) bool {
+	var notFound *NotFoundError
+	if errors.As(err, &notFound) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
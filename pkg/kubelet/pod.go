@@ -0,0 +1,117 @@
+package kubelet
+
+import (
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// SupplementalGroupsPolicy is the type of spec.securityContext.supplementalGroupsPolicy
+type SupplementalGroupsPolicy string
+
+const (
+	// SupplementalGroupsPolicyMerge merges gids defined in the container image with supplementalGroups
+	SupplementalGroupsPolicyMerge SupplementalGroupsPolicy = "Merge"
+	// SupplementalGroupsPolicyStrict uses only gids in the pod's SecurityContext
+	SupplementalGroupsPolicyStrict SupplementalGroupsPolicy = "Strict"
+)
+
+// Pod is corev1.Pod with the fields which are not available in the vendored k8s.io/api
+type Pod struct {
+	corev1.Pod
+
+	// SupplementalGroupsPolicy is spec.securityContext.supplementalGroupsPolicy (nil if not set)
+	SupplementalGroupsPolicy *SupplementalGroupsPolicy
+}
+
+// podExtension is the JSON structure for the fields which are not available in the vendored k8s.io/api
+type podExtension struct {
+	Spec struct {
+		SecurityContext *struct {
+			SupplementalGroupsPolicy *SupplementalGroupsPolicy `json:"supplementalGroupsPolicy,omitempty"`
+		} `json:"securityContext,omitempty"`
+	} `json:"spec"`
+}
+
+func (p *Pod) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &p.Pod); err != nil {
+		return err
+	}
+
+	var ext podExtension
+	if err := json.Unmarshal(data, &ext); err != nil {
+		return err
+	}
+	p.SupplementalGroupsPolicy = nil
+	if ext.Spec.SecurityContext != nil {
+		p.SupplementalGroupsPolicy = ext.Spec.SecurityContext.SupplementalGroupsPolicy
+	}
+	return nil
+}
+
+func (p Pod) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(&p.Pod)
+	if err != nil || p.SupplementalGroupsPolicy == nil {
+		return data, err
+	}
+
+	// put supplementalGroupsPolicy back to spec.securityContext
+	var obj map[string]interface{}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+	spec, _ := obj["spec"].(map[string]interface{})
+	if spec == nil {
+		spec = map[string]interface{}{}
+		obj["spec"] = spec
+	}
+	securityContext, _ := spec["securityContext"].(map[string]interface{})
+	if securityContext == nil {
+		securityContext = map[string]interface{}{}
+		spec["securityContext"] = securityContext
+	}
+	securityContext["supplementalGroupsPolicy"] = *p.SupplementalGroupsPolicy
+	return json.Marshal(obj)
+}
+
+// HasContainer reports whether the pod has the container in containers, initContainers or ephemeralContainers
+func (p *Pod) HasContainer(name string) bool {
+	for _, c := range p.Spec.Containers {
+		if c.Name == name {
+			return true
+		}
+	}
+	for _, c := range p.Spec.InitContainers {
+		if c.Name == name {
+			return true
+		}
+	}
+	for _, c := range p.Spec.EphemeralContainers {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// SecuritySubset returns a copy of the pod which has only the fields used for enforcement:
+// the pod's identity and security contexts of the pod and its containers.
+func (p *Pod) SecuritySubset() *Pod {
+	subset := &Pod{SupplementalGroupsPolicy: p.SupplementalGroupsPolicy}
+	subset.Namespace = p.Namespace
+	subset.Name = p.Name
+	subset.UID = p.UID
+	subset.Spec.SecurityContext = p.Spec.SecurityContext
+	for _, c := range p.Spec.Containers {
+		subset.Spec.Containers = append(subset.Spec.Containers, corev1.Container{Name: c.Name, SecurityContext: c.SecurityContext})
+	}
+	for _, c := range p.Spec.InitContainers {
+		subset.Spec.InitContainers = append(subset.Spec.InitContainers, corev1.Container{Name: c.Name, SecurityContext: c.SecurityContext})
+	}
+	for _, c := range p.Spec.EphemeralContainers {
+		subset.Spec.EphemeralContainers = append(subset.Spec.EphemeralContainers, corev1.EphemeralContainer{
+			EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: c.Name, SecurityContext: c.SecurityContext},
+		})
+	}
+	return subset
+}
@@ -0,0 +1,67 @@
+package kubelet
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Pod", func() {
+	It("decodes supplementalGroupsPolicy", func() {
+		var pod Pod
+		Expect(json.Unmarshal([]byte(`{
+			"metadata": {"namespace": "user-alice", "name": "pod"},
+			"spec": {"securityContext": {"supplementalGroups": [60000], "supplementalGroupsPolicy": "Strict"}}
+		}`), &pod)).To(Succeed())
+		Expect(pod.Namespace).To(Equal("user-alice"))
+		Expect(pod.Spec.SecurityContext.SupplementalGroups).To(Equal([]int64{60000}))
+		Expect(pod.SupplementalGroupsPolicy).To(HaveValue(Equal(SupplementalGroupsPolicyStrict)))
+	})
+
+	It("decodes pods without supplementalGroupsPolicy", func() {
+		var pod Pod
+		Expect(json.Unmarshal([]byte(`{"metadata": {"namespace": "user-alice", "name": "pod"}, "spec": {}}`), &pod)).To(Succeed())
+		Expect(pod.SupplementalGroupsPolicy).To(BeNil())
+	})
+
+	It("encodes supplementalGroupsPolicy", func() {
+		policy := SupplementalGroupsPolicyMerge
+		pod := Pod{SupplementalGroupsPolicy: &policy}
+		pod.Namespace, pod.Name = "user-alice", "pod"
+
+		data, err := json.Marshal(&pod)
+		Expect(err).NotTo(HaveOccurred())
+		var decoded Pod
+		Expect(json.Unmarshal(data, &decoded)).To(Succeed())
+		Expect(decoded.Namespace).To(Equal("user-alice"))
+		Expect(decoded.SupplementalGroupsPolicy).To(HaveValue(Equal(SupplementalGroupsPolicyMerge)))
+	})
+
+	It("keeps only security relevant fields in SecuritySubset", func() {
+		var pod Pod
+		Expect(json.Unmarshal([]byte(`{
+			"metadata": {"namespace": "user-alice", "name": "pod", "uid": "uid-1", "labels": {"app": "test"}},
+			"spec": {
+				"securityContext": {"supplementalGroups": [60000], "supplementalGroupsPolicy": "Strict"},
+				"containers": [{"name": "main", "image": "busybox", "securityContext": {"runAsUser": 1000}}],
+				"initContainers": [{"name": "init", "image": "busybox"}],
+				"ephemeralContainers": [{"name": "debug", "image": "busybox"}]
+			}
+		}`), &pod)).To(Succeed())
+
+		subset := pod.SecuritySubset()
+		Expect(subset.Namespace).To(Equal("user-alice"))
+		Expect(subset.Name).To(Equal("pod"))
+		Expect(string(subset.UID)).To(Equal("uid-1"))
+		Expect(subset.Labels).To(BeNil())
+		Expect(subset.Spec.SecurityContext.SupplementalGroups).To(Equal([]int64{60000}))
+		Expect(subset.SupplementalGroupsPolicy).To(HaveValue(Equal(SupplementalGroupsPolicyStrict)))
+		Expect(subset.Spec.Containers).To(HaveLen(1))
+		Expect(subset.Spec.Containers[0].Name).To(Equal("main"))
+		Expect(subset.Spec.Containers[0].Image).To(BeEmpty())
+		Expect(*subset.Spec.Containers[0].SecurityContext.RunAsUser).To(Equal(int64(1000)))
+		Expect(subset.Spec.InitContainers[0].Name).To(Equal("init"))
+		Expect(subset.Spec.EphemeralContainers[0].Name).To(Equal("debug"))
+	})
+})
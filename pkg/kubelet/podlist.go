@@ -0,0 +1,149 @@
+package kubelet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// podListItem is the subset of v1.Pod in v1.PodList.  Other fields (e.g. status) are skipped.
+type podListItem struct {
+	Metadata json.RawMessage `json:"metadata"`
+	Spec     json.RawMessage `json:"spec"`
+}
+
+// podListItemMeta is the subset of v1.ObjectMeta to find the pod in v1.PodList
+type podListItemMeta struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	UID       string `json:"uid"`
+}
+
+// meta decodes only the namespace/name/uid of the item
+func (i *podListItem) meta() (podListItemMeta, error) {
+	var meta podListItemMeta
+	if len(i.Metadata) > 0 {
+		if err := json.Unmarshal(i.Metadata, &meta); err != nil {
+			return meta, fmt.Errorf("Failed to unmarshal v1.Pod in HTTP response body: %v", err)
+		}
+	}
+	return meta, nil
+}
+
+// pod decodes metadata and spec of the item to kubelet.Pod for the fields which are not available in corev1.Pod
+func (i *podListItem) pod() (*Pod, error) {
+	data := []byte(`{"metadata":`)
+	if len(i.Metadata) > 0 {
+		data = append(data, i.Metadata...)
+	} else {
+		data = append(data, "null"...)
+	}
+	if len(i.Spec) > 0 {
+		data = append(data, `,"spec":`...)
+		data = append(data, i.Spec...)
+	}
+	data = append(data, '}')
+
+	var pod Pod
+	if err := json.Unmarshal(data, &pod); err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal v1.Pod in HTTP response body: %v", err)
+	}
+	return &pod, nil
+}
+
+// decodePodList decodes v1.PodList from r as a stream and calls visit for each item.
+// It stops reading when visit returns true or an error.  It returns the number of visited items.
+func decodePodList(r io.Reader, visit func(item *podListItem) (bool, error)) (int, error) {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return 0, err
+	}
+	visited := 0
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return visited, decodeError(err)
+		}
+		if key != "items" {
+			// skip other fields (e.g. kind, apiVersion, metadata)
+			var skipped json.RawMessage
+			if err := dec.Decode(&skipped); err != nil {
+				return visited, decodeError(err)
+			}
+			continue
+		}
+
+		if err := expectDelim(dec, '['); err != nil {
+			return visited, err
+		}
+		for dec.More() {
+			var item podListItem
+			if err := dec.Decode(&item); err != nil {
+				return visited, decodeError(err)
+			}
+			visited++
+			stop, err := visit(&item)
+			if err != nil || stop {
+				return visited, err
+			}
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return visited, err
+		}
+	}
+	return visited, nil
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return decodeError(err)
+	}
+	if token != delim {
+		return fmt.Errorf("Failed to decode HTTP response body to v1.PodList: %v found but %v is expected", token, delim)
+	}
+	return nil
+}
+
+func decodeError(err error) error {
+	var tooLarge *ResponseTooLargeError
+	if errors.As(err, &tooLarge) {
+		return err
+	}
+	return fmt.Errorf("Failed to decode HTTP response body to v1.PodList: %w", err)
+}
+
+// limitedReader is io.LimitedReader which fails with ResponseTooLargeError instead of io.EOF at the limit
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+	limit     int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		// the body exactly fitting in the limit is not an error
+		var probe [1]byte
+		n, err := l.r.Read(probe[:])
+		if n > 0 {
+			return 0, &ResponseTooLargeError{Limit: l.limit}
+		}
+		return 0, err
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
+
+type limitedReadCloser struct {
+	limitedReader
+	closer io.Closer
+}
+
+func (l *limitedReadCloser) Close() error {
+	return l.closer.Close()
+}
@@ -0,0 +1,54 @@
+package kubelet
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/config"
+)
+
+// GetPodWithRetry calls getPod until it succeeds, fails with an error which is not retryable (see IsRetryable),
+// or exhausts retries or the deadline in cfg.  ctx passed to getPod is cancelled at the deadline.
+// Each attempt is logged.  The final error tells how long it waited.
+func GetPodWithRetry(
+	logger zerolog.Logger,
+	cfg config.PodLookupConfig,
+	getPod func(ctx context.Context) (*Pod, error),
+) (*Pod, error) {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Deadline)
+	defer cancel()
+
+	backoff := cfg.Backoff
+	for attempt := 1; ; attempt++ {
+		pod, err := getPod(ctx)
+		elapsed := time.Since(start)
+		if err == nil {
+			logger.Debug().Int("Attempt", attempt).Dur("Elapsed", elapsed).Msg("Getting pod succeeded")
+			return pod, nil
+		}
+
+		retryable := IsRetryable(err)
+		retry := retryable && attempt <= cfg.Retries && elapsed+backoff < cfg.Deadline
+		logger.Warn().Err(err).
+			Int("Attempt", attempt).
+			Dur("Elapsed", elapsed).
+			Bool("Retryable", retryable).
+			Bool("Retry", retry).
+			Msg("Getting pod failed")
+		if !retry {
+			return nil, fmt.Errorf("%w (gave up after %d attempts in %v)", err, attempt, elapsed.Round(time.Millisecond))
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			elapsed = time.Since(start)
+			return nil, fmt.Errorf("%w (deadline %v exceeded after %d attempts in %v)", err, cfg.Deadline, attempt, elapsed.Round(time.Millisecond))
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,91 @@
+package kubelet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/rs/zerolog"
+
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/config"
+)
+
+var _ = Describe("IsRetryable", func() {
+	DescribeTable("classifies errors",
+		func(err error, expected bool) {
+			Expect(IsRetryable(err)).To(Equal(expected))
+		},
+		Entry("pod not found", &NotFoundError{Namespace: "user-alice", Name: "pod"}, true),
+		Entry("container not found", fmt.Errorf("wrapped: %w", &NotFoundError{Namespace: "user-alice", Name: "pod", Container: "debugger"}), true),
+		Entry("connection refused", fmt.Errorf("Failed to run HTTP request: %w", &url.Error{
+			Op: "Get", URL: "https://127.0.0.1:10250/pods",
+			Err: &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)},
+		}), true),
+		Entry("timeout", &url.Error{Op: "Get", URL: "https://127.0.0.1:10250/pods", Err: context.DeadlineExceeded}, true),
+		Entry("truncated response", fmt.Errorf("Failed to decode HTTP response body to v1.PodList: %w", io.ErrUnexpectedEOF), true),
+		Entry("non-200 response", &StatusError{StatusCode: http.StatusForbidden, Status: "403 Forbidden"}, false),
+		Entry("response too large", &ResponseTooLargeError{Limit: 1024}, false),
+		Entry("uid mismatch", errors.New("Pod UID mismatch"), false),
+	)
+})
+
+var _ = Describe("GetPodWithRetry", func() {
+	cfg := config.PodLookupConfig{Deadline: 10 * time.Second, Retries: 3, Backoff: time.Millisecond}
+	pod := &Pod{}
+
+	It("retries until the pod is found", func() {
+		attempts := 0
+		got, err := GetPodWithRetry(zerolog.Nop(), cfg, func(ctx context.Context) (*Pod, error) {
+			attempts++
+			if attempts < 3 {
+				return nil, &NotFoundError{Namespace: "user-alice", Name: "pod"}
+			}
+			return pod, nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got).To(BeIdenticalTo(pod))
+		Expect(attempts).To(Equal(3))
+	})
+
+	It("does not retry errors which are not retryable", func() {
+		attempts := 0
+		_, err := GetPodWithRetry(zerolog.Nop(), cfg, func(ctx context.Context) (*Pod, error) {
+			attempts++
+			return nil, &StatusError{StatusCode: http.StatusForbidden, Status: "403 Forbidden"}
+		})
+		Expect(err).To(MatchError(ContainSubstring("gave up after 1 attempts")))
+		Expect(attempts).To(Equal(1))
+	})
+
+	It("gives up after retries", func() {
+		attempts := 0
+		_, err := GetPodWithRetry(zerolog.Nop(), cfg, func(ctx context.Context) (*Pod, error) {
+			attempts++
+			return nil, &NotFoundError{Namespace: "user-alice", Name: "pod"}
+		})
+		var notFound *NotFoundError
+		Expect(errors.As(err, &notFound)).To(BeTrue())
+		Expect(err).To(MatchError(ContainSubstring("gave up after 4 attempts")))
+		Expect(attempts).To(Equal(4))
+	})
+
+	It("gives up at the deadline", func() {
+		cfg := config.PodLookupConfig{Deadline: 50 * time.Millisecond, Retries: 100, Backoff: 10 * time.Millisecond}
+		start := time.Now()
+		_, err := GetPodWithRetry(zerolog.Nop(), cfg, func(ctx context.Context) (*Pod, error) {
+			return nil, &NotFoundError{Namespace: "user-alice", Name: "pod"}
+		})
+		Expect(err).To(MatchError(ContainSubstring("Pod not found: user-alice/pod")))
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+	})
+})
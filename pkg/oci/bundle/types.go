@@ -23,6 +23,7 @@ type Bundle struct {
 type ContainerInfo struct {
 	PodNamespace  string
 	PodName       string
+	PodUID        string
 	ContainerType string
 	ContainerName string
 }
@@ -59,6 +60,12 @@ func (b *Bundle) GetContainerInfo(
 		return nil, fmt.Errorf("Failed to resolve Pod in OCI Spec: %v", err)
 	}
 
+	// pod's uid distinguishes the pod from the one recreated with the same namespace/name
+	podUID, err := b.getPodUID(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to resolve Pod in OCI Spec: %v", err)
+	}
+
 	containerName, err := b.getContainerName(cfg)
 	if err != nil {
 		logger.Warn().Err(err).Msg("Failed to resolve container name in OCI Spec. Ignored.")
@@ -68,6 +75,7 @@ func (b *Bundle) GetContainerInfo(
 		ContainerType: containerType,
 		PodNamespace:  podNamespace,
 		PodName:       podName,
+		PodUID:        podUID,
 		ContainerName: containerName,
 	}, nil
 }
@@ -96,6 +104,14 @@ func (b *Bundle) getPodName(cfg *config.Config) (string, string, error) {
 	return podNamespace, podName, nil
 }
 
+func (b *Bundle) getPodUID(cfg *config.Config) (string, error) {
+	podUID, ok := b.spec.Annotations[cfg.PodUidAnnotation]
+	if !ok || podUID == "" {
+		return "", fmt.Errorf("%s annotation not found or empty", cfg.PodUidAnnotation)
+	}
+	return podUID, nil
+}
+
 func (b *Bundle) getContainerName(cfg *config.Config) (string, error) {
 	containerName, ok := b.spec.Annotations[cfg.ContainerNameAnnotation]
 	if !ok {
@@ -1,68 +1,225 @@
 package runtime
 
 import (
+	"fmt"
 	"strings"
 
-	"github.com/jessevdk/go-flags"
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/config"
 )
 
 type Command string
 
 var (
-	CommandCreate Command = "create"
-	CommandStart  Command = "start"
-	CommandExec   Command = "exec"
+	CommandCheckpoint Command = "checkpoint"
+	CommandCreate     Command = "create"
+	CommandDelete     Command = "delete"
+	CommandEvents     Command = "events"
+	CommandExec       Command = "exec"
+	CommandFeatures   Command = "features"
+	CommandInit       Command = "init"
+	CommandKill       Command = "kill"
+	CommandList       Command = "list"
+	CommandPause      Command = "pause"
+	CommandPs         Command = "ps"
+	CommandRestore    Command = "restore"
+	CommandResume     Command = "resume"
+	CommandRun        Command = "run"
+	CommandSpec       Command = "spec"
+	CommandStart      Command = "start"
+	CommandState      Command = "state"
+	CommandUpdate     Command = "update"
 )
 
 type RuntimeArgs struct {
 	Command     Command
 	ContainerId string
-	Options     RuntimeOpts
+	// Args is positional arguments after the container id (e.g. command for exec, signal for kill)
+	Args    []string
+	Options RuntimeOpts
 }
 
 type RuntimeOpts struct {
 	// global flags
-	Root      string `long:"root"`
-	Log       string `long:"log"`
-	LogFormat string `long:"log-format"`
+	Root      string
+	Log       string
+	LogFormat string
+	Version   bool
 
-	// common flags for create/exec
-	PidFile string `long:"pid-file"`
+	// common flags for create/run/restore/exec
+	PidFile string
 
-	// flags for create
-	Bundle string `long:"bundle" short:"b"`
+	// flags for create/run/restore
+	Bundle string
+
+	// flags for checkpoint/restore
+	ImagePath string
 
 	// flags for exec
-	Process string `long:"process" short:"p"`
+	Process        string
+	User           string
+	AdditionalGids []string
+	Caps           []string
+	// NoNewPrivs is nil unless "--no-new-privs" is given
+	NoNewPrivs *bool
 }
 
 // GetRuntimeArgs analyze command line arguments passed from OCI to low level container runtime
-// and returns analysis result for later use
+// and returns analysis result for later use.  args[0] is the runtime binary itself.
+//
+// The arguments are parsed with runc's command line grammar:
+//
+//	runc [global options] <command> [command options] [<container-id> [arguments...]]
+//
+// Unknown flags are treated as boolean flags unless they are in "--flag=value" form.
 func GetRuntimeArgs(args []string) (*RuntimeArgs, error) {
-	runtimeOpts := RuntimeOpts{}
-	parser := flags.NewParser(&runtimeOpts, flags.IgnoreUnknown)
+	return GetRuntimeArgsWithFlags(args, config.RuntimeFlagsConfig{})
+}
+
+// GetRuntimeArgsWithFlags is the same as GetRuntimeArgs except names of flags carrying RuntimeOpts can be
+// renamed for runtimes whose flags differ from runc's.
+func GetRuntimeArgsWithFlags(args []string, flagNames config.RuntimeFlagsConfig) (*RuntimeArgs, error) {
+	runtimeArgs, _, err := parseArgs(args, flagNames, nil)
+	return runtimeArgs, err
+}
 
-	remainingArgs, err := parser.ParseArgs(args)
+// ReplaceCommandFlags returns a copy of args in which all occurrences of the command flags named names
+// are removed and replacement is inserted right after the command.  args are parsed with flagNames in the
+// same way as GetRuntimeArgsWithFlags.
+func ReplaceCommandFlags(args []string, flagNames config.RuntimeFlagsConfig, names []string, replacement []string) ([]string, error) {
+	removed := make([]bool, len(args))
+	_, commandIndex, err := parseArgs(args, flagNames, func(index, consumed int, flag *flagSpec) {
+		for _, name := range names {
+			if flag.has(name) {
+				for i := index; i < index+consumed; i++ {
+					removed[i] = true
+				}
+				return
+			}
+		}
+	})
 	if err != nil {
 		return nil, err
 	}
-	runtimeArgs := RuntimeArgs{Options: runtimeOpts}
+	if commandIndex < 0 {
+		return nil, fmt.Errorf("command not found in %v", args)
+	}
 
-	nonOptionArguments := []string{}
-	for _, r := range remainingArgs {
-		if !strings.HasPrefix(r, "-") {
-			nonOptionArguments = append(nonOptionArguments, r)
+	replaced := make([]string, 0, len(args)+len(replacement))
+	for i, arg := range args {
+		if removed[i] {
+			continue
 		}
+		replaced = append(replaced, arg)
+		if i == commandIndex {
+			replaced = append(replaced, replacement...)
+		}
+	}
+	return replaced, nil
+}
+
+// parseArgs parses args and returns the parse result and the index of the command in args (-1 if no command).
+// visit (if not nil) is called for each known flag after the command with its index and the number of arguments it consumed.
+func parseArgs(
+	args []string,
+	flagNames config.RuntimeFlagsConfig,
+	visit func(index, consumed int, flag *flagSpec),
+) (*RuntimeArgs, int, error) {
+	runtimeArgs := RuntimeArgs{}
+	if len(args) <= 1 {
+		return &runtimeArgs, -1, nil
 	}
-	if len(nonOptionArguments) >= 3 {
-		// argument[0] is strict-supplementalgroups-container-runtime itself
+	globalFlags := renameFlags(globalFlags, flagNames)
 
-		// command should come at first
-		runtimeArgs.Command = Command(nonOptionArguments[1])
+	// global options come before the command
+	i := 1
+	for i < len(args) && isFlag(args[i]) {
+		if args[i] == "--" {
+			i++
+			break
+		}
+		_, consumed, err := parseFlag(args[i:], globalFlags, &runtimeArgs.Options)
+		if err != nil {
+			return nil, -1, err
+		}
+		i += consumed
+	}
+	if i >= len(args) {
+		return &runtimeArgs, -1, nil
+	}
+	commandIndex := i
+	runtimeArgs.Command = Command(args[i])
+	i++
 
-		// container-id should come at the last
-		runtimeArgs.ContainerId = nonOptionArguments[len(nonOptionArguments)-1]
+	cmd, ok := commands[runtimeArgs.Command]
+	if !ok {
+		// unknown command: assume "<command> [options] <container-id>"
+		cmd = commandSpec{hasContainerId: true}
 	}
+	// global options placed after the command are also accepted unless shadowed by the command's options
+	flags := append(renameFlags(cmd.flags, flagNames), globalFlags...)
 
-	return &runtimeArgs, nil
+	positionals := []string{}
+	for i < len(args) {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			positionals = append(positionals, args[i+1:]...)
+			i = len(args)
+		case isFlag(arg) && !(cmd.skipArgReorder && len(positionals) > 0):
+			flag, consumed, err := parseFlag(args[i:], flags, &runtimeArgs.Options)
+			if err != nil {
+				return nil, -1, fmt.Errorf("%s: %w", runtimeArgs.Command, err)
+			}
+			if flag != nil && visit != nil {
+				visit(i, consumed, flag)
+			}
+			i += consumed
+		default:
+			positionals = append(positionals, arg)
+			i++
+		}
+	}
+
+	if cmd.hasContainerId && len(positionals) > 0 {
+		runtimeArgs.ContainerId = positionals[0]
+		positionals = positionals[1:]
+	}
+	if len(positionals) > 0 {
+		runtimeArgs.Args = positionals
+	}
+
+	return &runtimeArgs, commandIndex, nil
+}
+
+func isFlag(arg string) bool {
+	return len(arg) > 1 && strings.HasPrefix(arg, "-")
+}
+
+// parseFlag parses the flag at args[0] and returns the flag (nil if unknown) and the number of consumed arguments
+func parseFlag(args []string, flags flagSpecs, opts *RuntimeOpts) (*flagSpec, int, error) {
+	name := strings.TrimLeft(args[0], "-")
+	value, hasValue := "", false
+	if i := strings.Index(name, "="); i >= 0 {
+		name, value, hasValue = name[:i], name[i+1:], true
+	}
+
+	flag := flags.find(name)
+	if flag == nil {
+		// unknown flag: treated as a boolean flag
+		return nil, 1, nil
+	}
+
+	consumed := 1
+	if flag.takesValue && !hasValue {
+		if len(args) < 2 {
+			return nil, 0, fmt.Errorf("flag needs an argument: %s", args[0])
+		}
+		// the value is taken as is even if it starts with "-" (e.g. "--resources -")
+		value = args[1]
+		consumed = 2
+	}
+	if flag.option != nil {
+		flag.option(opts, value)
+	}
+	return flag, consumed, nil
 }
@@ -0,0 +1,260 @@
+package runtime
+
+import (
+	"strconv"
+
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/config"
+)
+
+// flagSpec describes a command line flag of runc
+type flagSpec struct {
+	// names are the flag names without leading dashes (e.g. "bundle", "b")
+	names []string
+	// takesValue is true when the flag takes a value
+	takesValue bool
+	// option stores the value to RuntimeOpts (nil if not stored)
+	option func(opts *RuntimeOpts, value string)
+}
+
+type flagSpecs []flagSpec
+
+func (fs flagSpecs) find(name string) *flagSpec {
+	for i := range fs {
+		if fs[i].has(name) {
+			return &fs[i]
+		}
+	}
+	return nil
+}
+
+func (f *flagSpec) has(name string) bool {
+	for _, n := range f.names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// commandSpec describes a subcommand of runc
+type commandSpec struct {
+	flags flagSpecs
+	// hasContainerId is true when the first positional argument is the container id
+	hasContainerId bool
+	// skipArgReorder is true when flags are parsed only before the first positional argument
+	// (e.g. "exec <container-id> <command> [command options]")
+	skipArgReorder bool
+}
+
+// renameFlags returns a copy of flags in which flags carrying RuntimeOpts are renamed by flagNames (if not empty).
+// Flags are identified by their first name in runc.
+func renameFlags(flags flagSpecs, flagNames config.RuntimeFlagsConfig) flagSpecs {
+	renames := map[string][]string{
+		"root":       flagNames.Root,
+		"log":        flagNames.Log,
+		"log-format": flagNames.LogFormat,
+		"bundle":     flagNames.Bundle,
+		"process":    flagNames.Process,
+	}
+	renamed := make(flagSpecs, len(flags))
+	for i, flag := range flags {
+		if names := renames[flag.names[0]]; len(names) > 0 {
+			flag.names = names
+		}
+		renamed[i] = flag
+	}
+	return renamed
+}
+
+func boolFlag(names ...string) flagSpec {
+	return flagSpec{names: names}
+}
+
+func valueFlag(names ...string) flagSpec {
+	return flagSpec{names: names, takesValue: true}
+}
+
+func boolOptionFlag(option func(opts *RuntimeOpts, value string), names ...string) flagSpec {
+	return flagSpec{names: names, option: option}
+}
+
+func optionFlag(option func(opts *RuntimeOpts, value string), names ...string) flagSpec {
+	return flagSpec{names: names, takesValue: true, option: option}
+}
+
+var (
+	optRoot           = func(o *RuntimeOpts, v string) { o.Root = v }
+	optLog            = func(o *RuntimeOpts, v string) { o.Log = v }
+	optLogFormat      = func(o *RuntimeOpts, v string) { o.LogFormat = v }
+	optVersion        = func(o *RuntimeOpts, _ string) { o.Version = true }
+	optPidFile        = func(o *RuntimeOpts, v string) { o.PidFile = v }
+	optBundle         = func(o *RuntimeOpts, v string) { o.Bundle = v }
+	optImagePath      = func(o *RuntimeOpts, v string) { o.ImagePath = v }
+	optProcess        = func(o *RuntimeOpts, v string) { o.Process = v }
+	optUser           = func(o *RuntimeOpts, v string) { o.User = v }
+	optAdditionalGids = func(o *RuntimeOpts, v string) { o.AdditionalGids = append(o.AdditionalGids, v) }
+	optCaps           = func(o *RuntimeOpts, v string) { o.Caps = append(o.Caps, v) }
+	optNoNewPrivs     = func(o *RuntimeOpts, v string) {
+		// "--no-new-privs" or "--no-new-privs=<bool>".  Invalid values are taken as false so that hardening is not skipped.
+		b, err := strconv.ParseBool(v)
+		noNewPrivs := v == "" || (err == nil && b)
+		o.NoNewPrivs = &noNewPrivs
+	}
+)
+
+var globalFlags = flagSpecs{
+	boolFlag("debug"),
+	optionFlag(optLog, "log"),
+	optionFlag(optLogFormat, "log-format"),
+	optionFlag(optRoot, "root"),
+	valueFlag("criu"),
+	boolFlag("systemd-cgroup"),
+	valueFlag("rootless"),
+	boolFlag("help", "h"),
+	boolOptionFlag(optVersion, "version", "v"),
+}
+
+// commands is the grammar of runc subcommands (as of runc v1.1)
+var commands = map[Command]commandSpec{
+	CommandCheckpoint: {
+		hasContainerId: true,
+		flags: flagSpecs{
+			optionFlag(optImagePath, "image-path"),
+			valueFlag("work-path"),
+			valueFlag("parent-path"),
+			boolFlag("leave-running"),
+			boolFlag("tcp-established"),
+			boolFlag("ext-unix-sk"),
+			boolFlag("shell-job"),
+			boolFlag("lazy-pages"),
+			valueFlag("status-fd"),
+			valueFlag("page-server"),
+			boolFlag("file-locks"),
+			boolFlag("pre-dump"),
+			valueFlag("manage-cgroups-mode"),
+			valueFlag("empty-ns"),
+			boolFlag("auto-dedup"),
+		},
+	},
+	CommandCreate: {
+		hasContainerId: true,
+		flags: flagSpecs{
+			optionFlag(optBundle, "bundle", "b"),
+			valueFlag("console-socket"),
+			optionFlag(optPidFile, "pid-file"),
+			boolFlag("no-pivot"),
+			boolFlag("no-new-keyring"),
+			valueFlag("preserve-fds"),
+		},
+	},
+	CommandDelete: {
+		hasContainerId: true,
+		flags:          flagSpecs{boolFlag("force", "f")},
+	},
+	CommandEvents: {
+		hasContainerId: true,
+		flags:          flagSpecs{valueFlag("interval"), boolFlag("stats")},
+	},
+	CommandExec: {
+		hasContainerId: true,
+		skipArgReorder: true,
+		flags: flagSpecs{
+			valueFlag("console-socket"),
+			valueFlag("cwd"),
+			valueFlag("env", "e"),
+			boolFlag("tty", "t"),
+			optionFlag(optUser, "user", "u"),
+			optionFlag(optAdditionalGids, "additional-gids", "g"),
+			optionFlag(optProcess, "process", "p"),
+			boolFlag("detach", "d"),
+			optionFlag(optPidFile, "pid-file"),
+			valueFlag("process-label"),
+			valueFlag("apparmor"),
+			boolOptionFlag(optNoNewPrivs, "no-new-privs"),
+			optionFlag(optCaps, "cap", "c"),
+			valueFlag("preserve-fds"),
+			valueFlag("cgroup"),
+			boolFlag("ignore-paused"),
+		},
+	},
+	CommandFeatures: {},
+	CommandInit:     {},
+	CommandKill: {
+		hasContainerId: true,
+		flags:          flagSpecs{boolFlag("all", "a")},
+	},
+	CommandList: {
+		flags: flagSpecs{valueFlag("format", "f"), boolFlag("quiet", "q")},
+	},
+	CommandPause: {hasContainerId: true},
+	CommandPs: {
+		hasContainerId: true,
+		skipArgReorder: true,
+		flags:          flagSpecs{valueFlag("format", "f")},
+	},
+	CommandRestore: {
+		hasContainerId: true,
+		flags: flagSpecs{
+			valueFlag("console-socket"),
+			optionFlag(optImagePath, "image-path"),
+			valueFlag("work-path"),
+			boolFlag("tcp-established"),
+			boolFlag("ext-unix-sk"),
+			boolFlag("shell-job"),
+			boolFlag("file-locks"),
+			valueFlag("manage-cgroups-mode"),
+			optionFlag(optBundle, "bundle", "b"),
+			boolFlag("detach", "d"),
+			optionFlag(optPidFile, "pid-file"),
+			boolFlag("no-subreaper"),
+			boolFlag("no-pivot"),
+			valueFlag("empty-ns"),
+			boolFlag("auto-dedup"),
+			boolFlag("lazy-pages"),
+			valueFlag("lsm-profile"),
+			valueFlag("lsm-mount-context"),
+		},
+	},
+	CommandResume: {hasContainerId: true},
+	CommandRun: {
+		hasContainerId: true,
+		flags: flagSpecs{
+			optionFlag(optBundle, "bundle", "b"),
+			valueFlag("console-socket"),
+			boolFlag("detach", "d"),
+			boolFlag("keep"),
+			optionFlag(optPidFile, "pid-file"),
+			boolFlag("no-subreaper"),
+			boolFlag("no-pivot"),
+			boolFlag("no-new-keyring"),
+			valueFlag("preserve-fds"),
+		},
+	},
+	CommandSpec: {
+		flags: flagSpecs{optionFlag(optBundle, "bundle", "b"), boolFlag("rootless")},
+	},
+	CommandStart: {hasContainerId: true},
+	CommandState: {hasContainerId: true},
+	CommandUpdate: {
+		hasContainerId: true,
+		flags: flagSpecs{
+			valueFlag("resources", "r"),
+			valueFlag("blkio-weight"),
+			valueFlag("cpu-period"),
+			valueFlag("cpu-quota"),
+			valueFlag("cpu-share"),
+			valueFlag("cpu-rt-period"),
+			valueFlag("cpu-rt-runtime"),
+			valueFlag("cpuset-cpus"),
+			valueFlag("cpuset-mems"),
+			valueFlag("kernel-memory"),
+			valueFlag("kernel-memory-tcp"),
+			valueFlag("memory"),
+			valueFlag("memory-reservation"),
+			valueFlag("memory-swap"),
+			valueFlag("pids-limit"),
+			valueFlag("l3-cache-schema"),
+			valueFlag("mem-bw-schema"),
+		},
+	},
+}
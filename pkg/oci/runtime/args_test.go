@@ -3,6 +3,10 @@ package runtime
 import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+
+	"k8s.io/utils/pointer"
+
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/config"
 )
 
 var _ = Describe("GetRuntimeArgs", func() {
@@ -159,5 +163,360 @@ var _ = Describe("GetRuntimeArgs", func() {
 				},
 			},
 		),
+		Entry(
+			"[cri-o] exec with command",
+			[]string{
+				bin,
+				"--root", root,
+				"--systemd-cgroup",
+				"exec",
+				"--pid-file", bundleDir + "/exec.pid",
+				"--process", process,
+				"-u", "1000:1000",
+				containerId,
+				"sh", "-c", "id -G",
+			},
+			&RuntimeArgs{
+				Command:     CommandExec,
+				ContainerId: containerId,
+				Args:        []string{"sh", "-c", "id -G"},
+				Options: RuntimeOpts{
+					Root:    root,
+					PidFile: bundleDir + "/exec.pid",
+					Process: process,
+					User:    "1000:1000",
+				},
+			},
+		),
+
+		Entry(
+			"exec with user options",
+			[]string{
+				bin,
+				"--root", root,
+				"exec",
+				"--user", "1000:1000",
+				"-g", "2000",
+				"--additional-gids=3000",
+				"--tty",
+				containerId,
+				"id", "-G",
+			},
+			&RuntimeArgs{
+				Command:     CommandExec,
+				ContainerId: containerId,
+				Args:        []string{"id", "-G"},
+				Options: RuntimeOpts{
+					Root:           root,
+					User:           "1000:1000",
+					AdditionalGids: []string{"2000", "3000"},
+				},
+			},
+		),
+
+		Entry(
+			"exec with capabilities options",
+			[]string{
+				bin,
+				"--root", root,
+				"exec",
+				"--cap", "CAP_SETGID",
+				"-c=CAP_NET_RAW",
+				"--no-new-privs=false",
+				containerId,
+				"id", "-G",
+			},
+			&RuntimeArgs{
+				Command:     CommandExec,
+				ContainerId: containerId,
+				Args:        []string{"id", "-G"},
+				Options: RuntimeOpts{
+					Root:       root,
+					Caps:       []string{"CAP_SETGID", "CAP_NET_RAW"},
+					NoNewPrivs: pointer.Bool(false),
+				},
+			},
+		),
+
+		// other subcommands
+		Entry(
+			"[containerd] run",
+			[]string{
+				bin,
+				"--root", root,
+				"--log", bundleDir + "/log.json",
+				"--log-format", "json",
+				"run",
+				"-d",
+				"-b", bundleDir,
+				"--pid-file=" + bundleDir + "/init.pid",
+				containerId,
+			},
+			&RuntimeArgs{
+				Command:     CommandRun,
+				ContainerId: containerId,
+				Options: RuntimeOpts{
+					Root:      root,
+					Log:       bundleDir + "/log.json",
+					LogFormat: "json",
+					PidFile:   bundleDir + "/init.pid",
+					Bundle:    bundleDir,
+				},
+			},
+		),
+		Entry(
+			"[containerd] restore",
+			[]string{
+				bin,
+				"--root", root,
+				"--log", bundleDir + "/log.json",
+				"--log-format", "json",
+				"restore",
+				"--detach",
+				"--image-path", "/var/lib/checkpoint/image",
+				"--work-path", "/var/lib/checkpoint/work",
+				"--bundle", bundleDir,
+				"--pid-file", bundleDir + "/init.pid",
+				containerId,
+			},
+			&RuntimeArgs{
+				Command:     CommandRestore,
+				ContainerId: containerId,
+				Options: RuntimeOpts{
+					Root:      root,
+					Log:       bundleDir + "/log.json",
+					LogFormat: "json",
+					PidFile:   bundleDir + "/init.pid",
+					Bundle:    bundleDir,
+					ImagePath: "/var/lib/checkpoint/image",
+				},
+			},
+		),
+		Entry(
+			"[containerd] checkpoint",
+			[]string{
+				bin,
+				"--root", root,
+				"checkpoint",
+				"--image-path", "/var/lib/checkpoint/image",
+				"--work-path", "/var/lib/checkpoint/work",
+				"--leave-running",
+				containerId,
+			},
+			&RuntimeArgs{
+				Command:     CommandCheckpoint,
+				ContainerId: containerId,
+				Options: RuntimeOpts{
+					Root:      root,
+					ImagePath: "/var/lib/checkpoint/image",
+				},
+			},
+		),
+		Entry(
+			"[containerd] delete",
+			[]string{
+				bin,
+				"--root", root,
+				"--log", bundleDir + "/log.json",
+				"--log-format", "json",
+				"delete",
+				"--force",
+				containerId,
+			},
+			&RuntimeArgs{
+				Command:     CommandDelete,
+				ContainerId: containerId,
+				Options: RuntimeOpts{
+					Root:      root,
+					Log:       bundleDir + "/log.json",
+					LogFormat: "json",
+				},
+			},
+		),
+		Entry(
+			"[containerd] kill",
+			[]string{
+				bin,
+				"--root", root,
+				"kill",
+				"--all",
+				containerId,
+				"9",
+			},
+			&RuntimeArgs{
+				Command:     CommandKill,
+				ContainerId: containerId,
+				Args:        []string{"9"},
+				Options:     RuntimeOpts{Root: root},
+			},
+		),
+		Entry(
+			"[cri-o] state",
+			[]string{
+				bin,
+				"--root=" + root,
+				"--systemd-cgroup",
+				"state",
+				containerId,
+			},
+			&RuntimeArgs{
+				Command:     CommandState,
+				ContainerId: containerId,
+				Options:     RuntimeOpts{Root: root},
+			},
+		),
+		Entry(
+			"[containerd] update reading resources from stdin",
+			[]string{
+				bin,
+				"--root", root,
+				"update",
+				"--resources", "-",
+				containerId,
+			},
+			&RuntimeArgs{
+				Command:     CommandUpdate,
+				ContainerId: containerId,
+				Options:     RuntimeOpts{Root: root},
+			},
+		),
+		Entry(
+			"[containerd] pause",
+			[]string{bin, "--root", root, "pause", containerId},
+			&RuntimeArgs{
+				Command:     CommandPause,
+				ContainerId: containerId,
+				Options:     RuntimeOpts{Root: root},
+			},
+		),
+		Entry(
+			"[containerd] resume",
+			[]string{bin, "--root", root, "resume", containerId},
+			&RuntimeArgs{
+				Command:     CommandResume,
+				ContainerId: containerId,
+				Options:     RuntimeOpts{Root: root},
+			},
+		),
+		Entry(
+			"[containerd] ps",
+			[]string{bin, "--root", root, "ps", "--format", "json", containerId},
+			&RuntimeArgs{
+				Command:     CommandPs,
+				ContainerId: containerId,
+				Options:     RuntimeOpts{Root: root},
+			},
+		),
+		Entry(
+			"[containerd] events",
+			[]string{bin, "--root", root, "events", "--stats", containerId},
+			&RuntimeArgs{
+				Command:     CommandEvents,
+				ContainerId: containerId,
+				Options:     RuntimeOpts{Root: root},
+			},
+		),
+		Entry(
+			"[containerd] features",
+			[]string{bin, "features"},
+			&RuntimeArgs{
+				Command: CommandFeatures,
+			},
+		),
+		Entry(
+			"global flags after command",
+			[]string{
+				bin,
+				"start",
+				"--root", root,
+				"--log-format=json",
+				containerId,
+			},
+			&RuntimeArgs{
+				Command:     CommandStart,
+				ContainerId: containerId,
+				Options: RuntimeOpts{
+					Root:      root,
+					LogFormat: "json",
+				},
+			},
+		),
+		Entry(
+			"flags after container id",
+			[]string{
+				bin,
+				"create",
+				containerId,
+				"--bundle", bundleDir,
+			},
+			&RuntimeArgs{
+				Command:     CommandCreate,
+				ContainerId: containerId,
+				Options: RuntimeOpts{
+					Bundle: bundleDir,
+				},
+			},
+		),
+		Entry(
+			"global flags only",
+			[]string{bin, "--version"},
+			&RuntimeArgs{Options: RuntimeOpts{Version: true}},
+		),
 	)
+
+	DescribeTable("invalid arguments",
+		func(args []string) {
+			_, err := GetRuntimeArgs(args)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("missing global flag value", []string{bin, "--root"}),
+		Entry("missing command flag value", []string{bin, "create", containerId, "--bundle"}),
+	)
+
+	DescribeTable("ReplaceCommandFlags",
+		func(args []string, expected []string) {
+			got, err := ReplaceCommandFlags(args, config.RuntimeFlagsConfig{}, []string{"user", "additional-gids"}, []string{"--user", "1000:1000"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got).To(Equal(expected))
+		},
+		Entry(
+			"no flags to replace",
+			[]string{bin, "--root", root, "exec", "--tty", containerId, "id"},
+			[]string{bin, "--root", root, "exec", "--user", "1000:1000", "--tty", containerId, "id"},
+		),
+		Entry(
+			"all forms of flags are replaced",
+			[]string{bin, "--root", root, "exec", "-u", "0", "--tty", "--user=0:0", "-g", "10", "--additional-gids", "20", containerId, "id", "-u", "-g"},
+			[]string{bin, "--root", root, "exec", "--user", "1000:1000", "--tty", containerId, "id", "-u", "-g"},
+		),
+	)
+
+	It("replaces flags with renamed flags", func() {
+		// "--state-dir <dir>" is taken as a boolean flag and <dir> as the command without the renamed flags
+		args := []string{bin, "--state-dir", root, "exec", "-u", "0", containerId, "id"}
+		got, err := ReplaceCommandFlags(
+			args,
+			config.RuntimeFlagsConfig{Root: []string{"state-dir"}},
+			[]string{"user", "additional-gids"},
+			[]string{"--user", "1000:1000"},
+		)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got).To(Equal([]string{bin, "--state-dir", root, "exec", "--user", "1000:1000", containerId, "id"}))
+	})
+
+	It("renames flags carrying options", func() {
+		got, err := GetRuntimeArgsWithFlags(
+			[]string{bin, "--state-dir", root, "create", "--spec-dir", bundleDir, containerId},
+			config.RuntimeFlagsConfig{Root: []string{"state-dir"}, Bundle: []string{"spec-dir"}},
+		)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got).To(BeEquivalentTo(&RuntimeArgs{
+			Command:     CommandCreate,
+			ContainerId: containerId,
+			Options: RuntimeOpts{
+				Root:   root,
+				Bundle: bundleDir,
+			},
+		}))
+	})
 })
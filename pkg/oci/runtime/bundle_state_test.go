@@ -0,0 +1,140 @@
+package runtime
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/config"
+)
+
+const testContainerId = "48bea6a58de41cdcae1521af1e3849400e498b9535f4d54a29771e8e0c67acf9"
+
+// setupRuntimeState creates a bundle, runc's state file and a fake runtime printing the state
+// in dir, and returns the runtime for them
+func setupRuntimeState(dir string) (*strictSupplementalGroupsRuntime, string, string, error) {
+	root := filepath.Join(dir, "root")
+	bundleDir := filepath.Join(dir, "bundle")
+	for _, d := range []string{filepath.Join(root, testContainerId), bundleDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return nil, "", "", err
+		}
+	}
+	if err := os.WriteFile(filepath.Join(bundleDir, "config.json"), []byte(`{"ociVersion":"1.0.2"}`), 0644); err != nil {
+		return nil, "", "", err
+	}
+	stateFile := fmt.Sprintf(`{"id":%q,"config":{"labels":["bundle=%s"]}}`, testContainerId, bundleDir)
+	if err := os.WriteFile(filepath.Join(root, testContainerId, "state.json"), []byte(stateFile), 0644); err != nil {
+		return nil, "", "", err
+	}
+	runtime := filepath.Join(dir, "runc")
+	script := fmt.Sprintf("#!/bin/sh\necho '{\"id\":%q,\"bundle\":%q}'\n", testContainerId, bundleDir)
+	if err := os.WriteFile(runtime, []byte(script), 0755); err != nil {
+		return nil, "", "", err
+	}
+
+	profile := config.RuntimeProfileConfig{
+		StateArgs:            []string{"--root", "{root}", "state", "{id}"},
+		StateBundleField:     "bundle",
+		StateFile:            "{root}/{id}/state.json",
+		StateFileBundleField: "config.labels=bundle",
+	}
+	r := &strictSupplementalGroupsRuntime{cfg: &config.Config{Runtime: runtime, ResolvedRuntimeProfile: profile}}
+	return r, root, bundleDir, nil
+}
+
+var _ = Describe("getBundleForContainer", func() {
+	var r *strictSupplementalGroupsRuntime
+	var root, bundleDir string
+	BeforeEach(func() {
+		var err error
+		r, root, bundleDir, err = setupRuntimeState(GinkgoT().TempDir())
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("resolves the bundle from the state file", func() {
+		Expect(r.getBundleDirFromStateFile(root, testContainerId)).To(Equal(bundleDir))
+		b, err := r.getBundleForContainer(root, testContainerId)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b.Dir).To(Equal(bundleDir))
+	})
+
+	It("resolves the bundle by the state command", func() {
+		Expect(r.getBundleDirFromStateCommand(root, testContainerId)).To(Equal(bundleDir))
+	})
+
+	It("falls back to the state command when the state file can't be read", func() {
+		Expect(os.Remove(filepath.Join(root, testContainerId, "state.json"))).To(Succeed())
+		b, err := r.getBundleForContainer(root, testContainerId)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b.Dir).To(Equal(bundleDir))
+	})
+
+	It("doesn't read state file out of the root", func() {
+		_, err := r.getBundleDirFromStateFile(root, "../"+testContainerId)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = DescribeTable("built-in runtime profiles",
+	func(name, defaultRoot string) {
+		dir := GinkgoT().TempDir()
+		bundleDir := filepath.Join(dir, "bundle")
+		runtime := filepath.Join(dir, name)
+		// the fake runtime prints the state only for the expected state command
+		script := fmt.Sprintf(
+			"#!/bin/sh\n[ \"$*\" = %q ] || exit 1\necho '{\"id\":%q,\"bundle\":%q}'\n",
+			"--root "+defaultRoot+" state "+testContainerId, testContainerId, bundleDir,
+		)
+		Expect(os.WriteFile(runtime, []byte(script), 0755)).To(Succeed())
+
+		cfg := &config.Config{Runtime: runtime, RuntimeProfile: name}
+		profile, err := config.ResolveRuntimeProfile(cfg)
+		Expect(err).NotTo(HaveOccurred())
+		cfg.ResolvedRuntimeProfile = profile
+		r := &strictSupplementalGroupsRuntime{cfg: cfg}
+
+		crArgs, err := GetRuntimeArgsWithFlags([]string{runtime, "create", "--bundle", bundleDir, testContainerId}, profile.Flags)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(crArgs.Options.Bundle).To(Equal(bundleDir))
+
+		root := r.runtimeRoot(crArgs.Options.Root)
+		Expect(root).To(Equal(defaultRoot))
+		Expect(r.getBundleDirFromStateCommand(root, testContainerId)).To(Equal(bundleDir))
+	},
+	Entry("runc", config.RuntimeProfileRunc, "/run/runc"),
+	Entry("crun", config.RuntimeProfileCrun, "/run/crun"),
+	Entry("youki", config.RuntimeProfileYouki, "/run/youki"),
+	Entry("runsc", config.RuntimeProfileRunsc, "/var/run/runsc"),
+	Entry("kata-runtime", config.RuntimeProfileKataRuntime, "/run/vc"),
+)
+
+func BenchmarkGetBundleDirFromStateFile(b *testing.B) {
+	r, root, _, err := setupRuntimeState(b.TempDir())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := r.getBundleDirFromStateFile(root, testContainerId); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetBundleDirFromStateCommand(b *testing.B) {
+	r, root, _, err := setupRuntimeState(b.TempDir())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := r.getBundleDirFromStateCommand(root, testContainerId); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
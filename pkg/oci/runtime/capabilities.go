@@ -0,0 +1,93 @@
+package runtime
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/rs/zerolog"
+
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/config"
+)
+
+// capabilitiesDecision is the result of enforcing capabilities.  It is logged with the gid enforcement.
+type capabilitiesDecision string
+
+const (
+	capabilitiesDecisionIgnored  capabilitiesDecision = "ignored"
+	capabilitiesDecisionExempted capabilitiesDecision = "exempted"
+	capabilitiesDecisionAllowed  capabilitiesDecision = "allowed"
+	capabilitiesDecisionDropped  capabilitiesDecision = "dropped"
+	capabilitiesDecisionAudited  capabilitiesDecision = "audited"
+)
+
+// enforceCapabilitiesOnProcessSpec drops or rejects capabilities which allow processes to regain dropped groups.
+// It returns the decision and the violated capabilities.
+func (r *strictSupplementalGroupsRuntime) enforceCapabilitiesOnProcessSpec(
+	logger zerolog.Logger,
+	processSpec *specs.Process,
+	namespace string,
+	action config.EnforcementAction,
+) (capabilitiesDecision, []string, error) {
+	capCfg := r.cfg.Capabilities
+	if capCfg.Policy == "" || capCfg.Policy == config.CapabilitiesPolicyIgnore {
+		return capabilitiesDecisionIgnored, nil, nil
+	}
+	for _, pattern := range capCfg.ExemptNamespaces {
+		if matchPattern(pattern, namespace) {
+			return capabilitiesDecisionExempted, nil, nil
+		}
+	}
+
+	caps := processSpec.Capabilities
+	if caps == nil {
+		return capabilitiesDecisionAllowed, nil, nil
+	}
+	forbidden := map[string]struct{}{}
+	for _, c := range capCfg.Capabilities {
+		forbidden[c] = struct{}{}
+	}
+	violated := map[string]struct{}{}
+	dropped := func(capabilities []string) []string {
+		if capabilities == nil {
+			return nil
+		}
+		result := []string{}
+		for _, c := range capabilities {
+			if _, ok := forbidden[c]; ok {
+				violated[c] = struct{}{}
+			} else {
+				result = append(result, c)
+			}
+		}
+		return result
+	}
+	bounding, effective, permitted := dropped(caps.Bounding), dropped(caps.Effective), dropped(caps.Permitted)
+	inheritable, ambient := dropped(caps.Inheritable), dropped(caps.Ambient)
+	if len(violated) == 0 {
+		return capabilitiesDecisionAllowed, nil, nil
+	}
+	violatedCapabilities := []string{}
+	for c := range violated {
+		violatedCapabilities = append(violatedCapabilities, c)
+	}
+	sort.Strings(violatedCapabilities)
+
+	var fix func()
+	if capCfg.Policy == config.CapabilitiesPolicyDrop {
+		fix = func() {
+			caps.Bounding, caps.Effective, caps.Permitted = bounding, effective, permitted
+			caps.Inheritable, caps.Ambient = inheritable, ambient
+		}
+	}
+	violation := fmt.Errorf("capabilities %v allow processes to regain dropped groups", violatedCapabilities)
+	logger = logger.With().Strs("violatedCapabilities", violatedCapabilities).Logger()
+	enforced, err := resolveViolation(logger, action, violation, fix)
+	if err != nil {
+		return "", violatedCapabilities, err
+	}
+	if !enforced {
+		return capabilitiesDecisionAudited, violatedCapabilities, nil
+	}
+	return capabilitiesDecisionDropped, violatedCapabilities, nil
+}
@@ -0,0 +1,84 @@
+package runtime
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	zlog "github.com/rs/zerolog/log"
+
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/config"
+)
+
+var _ = Describe("enforceCapabilitiesOnProcessSpec", func() {
+	defaultCaps := []string{"CAP_CHOWN", "CAP_SETGID", "CAP_SETUID", "CAP_NET_BIND_SERVICE"}
+	newProcessSpec := func() *specs.Process {
+		return &specs.Process{
+			Capabilities: &specs.LinuxCapabilities{
+				Bounding:  append([]string{}, defaultCaps...),
+				Effective: append([]string{}, defaultCaps...),
+				Permitted: append([]string{}, defaultCaps...),
+				Ambient:   []string{"CAP_SYS_ADMIN"},
+			},
+		}
+	}
+	newRuntime := func(policy config.CapabilitiesPolicy) strictSupplementalGroupsRuntime {
+		return strictSupplementalGroupsRuntime{cfg: &config.Config{
+			Capabilities: config.CapabilitiesConfig{
+				Policy:           policy,
+				Capabilities:     []string{"CAP_SETGID", "CAP_SETUID", "CAP_SETFCAP", "CAP_SYS_ADMIN"},
+				ExemptNamespaces: []string{"kube-*"},
+			},
+		}}
+	}
+	allViolated := []string{"CAP_SETGID", "CAP_SETUID", "CAP_SYS_ADMIN"}
+
+	It("drop: drops the capabilities from all the sets", func() {
+		r := newRuntime(config.CapabilitiesPolicyDrop)
+		processSpec := newProcessSpec()
+		decision, violated, err := r.enforceCapabilitiesOnProcessSpec(zlog.Logger, processSpec, "user-alice", config.EnforcementActionDrop)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(decision).To(Equal(capabilitiesDecisionDropped))
+		Expect(violated).To(Equal(allViolated))
+		Expect(processSpec.Capabilities).To(Equal(&specs.LinuxCapabilities{
+			Bounding:  []string{"CAP_CHOWN", "CAP_NET_BIND_SERVICE"},
+			Effective: []string{"CAP_CHOWN", "CAP_NET_BIND_SERVICE"},
+			Permitted: []string{"CAP_CHOWN", "CAP_NET_BIND_SERVICE"},
+			Ambient:   []string{},
+		}))
+	})
+
+	It("reject: rejects the container", func() {
+		r := newRuntime(config.CapabilitiesPolicyReject)
+		_, _, err := r.enforceCapabilitiesOnProcessSpec(zlog.Logger, newProcessSpec(), "user-alice", config.EnforcementActionDrop)
+		Expect(err).To(MatchError(ContainSubstring("CAP_SETGID")))
+	})
+
+	It("audit: keeps the capabilities", func() {
+		r := newRuntime(config.CapabilitiesPolicyDrop)
+		processSpec := newProcessSpec()
+		decision, violated, err := r.enforceCapabilitiesOnProcessSpec(zlog.Logger, processSpec, "user-alice", config.EnforcementActionAudit)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(decision).To(Equal(capabilitiesDecisionAudited))
+		Expect(violated).To(Equal(allViolated))
+		Expect(processSpec).To(Equal(newProcessSpec()))
+	})
+
+	It("keeps the capabilities in exempted namespaces", func() {
+		r := newRuntime(config.CapabilitiesPolicyReject)
+		processSpec := newProcessSpec()
+		decision, _, err := r.enforceCapabilitiesOnProcessSpec(zlog.Logger, processSpec, "kube-system", config.EnforcementActionDrop)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(decision).To(Equal(capabilitiesDecisionExempted))
+		Expect(processSpec).To(Equal(newProcessSpec()))
+	})
+
+	It("allows the container without the capabilities", func() {
+		r := newRuntime(config.CapabilitiesPolicyReject)
+		processSpec := &specs.Process{Capabilities: &specs.LinuxCapabilities{Bounding: []string{"CAP_CHOWN"}}}
+		decision, violated, err := r.enforceCapabilitiesOnProcessSpec(zlog.Logger, processSpec, "user-alice", config.EnforcementActionDeny)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(decision).To(Equal(capabilitiesDecisionAllowed))
+		Expect(violated).To(BeEmpty())
+	})
+})
@@ -0,0 +1,216 @@
+package runtime
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	// magic numbers of CRIU images (see criu/include/magic.h)
+	criuImgCommonMagic  = uint32(0x54564319)
+	criuImgServiceMagic = uint32(0x55105940)
+	criuCoreMagic       = uint32(0x55053847)
+
+	// field numbers in CRIU images (see images/core.proto and images/creds.proto)
+	criuCoreEntryThreadCore  = protowire.Number(5)
+	criuThreadCoreEntryCreds = protowire.Number(10)
+	criuCredsEntryUid        = protowire.Number(1)
+	criuCredsEntryGid        = protowire.Number(2)
+	criuCredsEntryEgid       = protowire.Number(4)
+	criuCredsEntrySgid       = protowire.Number(6)
+	criuCredsEntryFsgid      = protowire.Number(8)
+	criuCredsEntryGroups     = protowire.Number(14)
+
+	// maxCriuCoreImageEntrySizeByte is the limit of the size of core_entry to read
+	maxCriuCoreImageEntrySizeByte = 16 * 1024 * 1024
+)
+
+// criuCreds is the credentials of a checkpointed thread
+type criuCreds struct {
+	// File is the core image file name (e.g. core-1.img)
+	File   string
+	UID    uint32
+	GID    uint32
+	Egid   uint32
+	Sgid   uint32
+	Fsgid  uint32
+	Groups []uint32
+}
+
+// readCriuCreds reads the credentials of all the checkpointed threads from core-*.img in the CRIU image directory.
+// It fails when any of the images has no credentials so that the checkpoint can't bypass verification.
+func readCriuCreds(imagePath string) ([]criuCreds, error) {
+	files, err := filepath.Glob(filepath.Join(imagePath, "core-*.img"))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to find core images in %s: %v", imagePath, err)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no core images in %s", imagePath)
+	}
+	sort.Strings(files)
+
+	credsList := []criuCreds{}
+	for _, file := range files {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read core image: %v", err)
+		}
+		creds, err := parseCriuCoreImage(raw)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse core image %s: %v", file, err)
+		}
+		creds.File = filepath.Base(file)
+		credsList = append(credsList, *creds)
+	}
+	return credsList, nil
+}
+
+// parseCriuCoreImage returns the credentials in the core image.  The image is the magic numbers followed by
+// a core_entry message prefixed with its size.
+func parseCriuCoreImage(raw []byte) (*criuCreds, error) {
+	readUint32 := func() (uint32, error) {
+		if len(raw) < 4 {
+			return 0, fmt.Errorf("unexpected end of image")
+		}
+		v := binary.LittleEndian.Uint32(raw)
+		raw = raw[4:]
+		return v, nil
+	}
+
+	magic, err := readUint32()
+	if err != nil {
+		return nil, err
+	}
+	if magic == criuImgCommonMagic || magic == criuImgServiceMagic {
+		if magic, err = readUint32(); err != nil {
+			return nil, err
+		}
+	}
+	if magic != criuCoreMagic {
+		return nil, fmt.Errorf("unexpected magic %#x", magic)
+	}
+	size, err := readUint32()
+	if err != nil {
+		return nil, err
+	}
+	if size > maxCriuCoreImageEntrySizeByte || int(size) > len(raw) {
+		return nil, fmt.Errorf("invalid core entry size %d", size)
+	}
+
+	threadCore, err := findProtobufMessage(raw[:size], criuCoreEntryThreadCore)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse core entry: %v", err)
+	}
+	if threadCore == nil {
+		return nil, fmt.Errorf("core entry has no thread_core")
+	}
+	credsRaw, err := findProtobufMessage(threadCore, criuThreadCoreEntryCreds)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse thread_core entry: %v", err)
+	}
+	if credsRaw == nil {
+		return nil, fmt.Errorf("thread_core entry has no creds")
+	}
+	return parseCriuCredsEntry(credsRaw)
+}
+
+func parseCriuCredsEntry(raw []byte) (*criuCreds, error) {
+	creds := criuCreds{}
+	found := map[protowire.Number]bool{}
+	for len(raw) > 0 {
+		num, typ, n := protowire.ConsumeTag(raw)
+		if n < 0 {
+			return nil, fmt.Errorf("Failed to parse creds entry: %v", protowire.ParseError(n))
+		}
+		raw = raw[n:]
+
+		var values []uint64
+		switch {
+		case typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(raw)
+			if n < 0 {
+				return nil, fmt.Errorf("Failed to parse creds entry: %v", protowire.ParseError(n))
+			}
+			raw = raw[n:]
+			values = []uint64{v}
+		case typ == protowire.BytesType && num == criuCredsEntryGroups:
+			// packed repeated field
+			packed, n := protowire.ConsumeBytes(raw)
+			if n < 0 {
+				return nil, fmt.Errorf("Failed to parse creds entry: %v", protowire.ParseError(n))
+			}
+			raw = raw[n:]
+			for len(packed) > 0 {
+				v, n := protowire.ConsumeVarint(packed)
+				if n < 0 {
+					return nil, fmt.Errorf("Failed to parse creds entry: %v", protowire.ParseError(n))
+				}
+				packed = packed[n:]
+				values = append(values, v)
+			}
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, raw)
+			if n < 0 {
+				return nil, fmt.Errorf("Failed to parse creds entry: %v", protowire.ParseError(n))
+			}
+			raw = raw[n:]
+			continue
+		}
+
+		found[num] = true
+		for _, v := range values {
+			switch num {
+			case criuCredsEntryUid:
+				creds.UID = uint32(v)
+			case criuCredsEntryGid:
+				creds.GID = uint32(v)
+			case criuCredsEntryEgid:
+				creds.Egid = uint32(v)
+			case criuCredsEntrySgid:
+				creds.Sgid = uint32(v)
+			case criuCredsEntryFsgid:
+				creds.Fsgid = uint32(v)
+			case criuCredsEntryGroups:
+				creds.Groups = append(creds.Groups, uint32(v))
+			}
+		}
+	}
+	for _, num := range []protowire.Number{criuCredsEntryUid, criuCredsEntryGid, criuCredsEntryEgid, criuCredsEntrySgid, criuCredsEntryFsgid} {
+		if !found[num] {
+			return nil, fmt.Errorf("creds entry has no field %d", num)
+		}
+	}
+	return &creds, nil
+}
+
+// findProtobufMessage returns the last embedded message of the field in the message (nil if not found)
+func findProtobufMessage(raw []byte, field protowire.Number) ([]byte, error) {
+	var found []byte
+	for len(raw) > 0 {
+		num, typ, n := protowire.ConsumeTag(raw)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		raw = raw[n:]
+		if num == field && typ == protowire.BytesType {
+			v, n := protowire.ConsumeBytes(raw)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			found = v
+			raw = raw[n:]
+			continue
+		}
+		n = protowire.ConsumeFieldValue(num, typ, raw)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		raw = raw[n:]
+	}
+	return found, nil
+}
@@ -0,0 +1,232 @@
+package runtime
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/atomicfile"
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/config"
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/kubelet"
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/oci/bundle"
+)
+
+// enforcementDecision is the enforcement decision made on create.  start and exec reuse it
+// so that they don't need to get the pod from kubelet.
+type enforcementDecision struct {
+	PodUID        string                   `json:"podUID"`
+	ContainerName string                   `json:"containerName"`
+	Action        config.EnforcementAction `json:"action"`
+	// AllowedGids is gids allowed by the pod (supplementalGroups ∪ fsGroup) restricted by the namespace's entitlement.
+	// The group database is applied on each command because it depends on the uid of the process.
+	AllowedGids []int64 `json:"allowedGids"`
+	// Pod is the subset of the pod used for enforcement
+	Pod *kubelet.Pod `json:"pod"`
+}
+
+// getEnforcementDecision returns the enforcement decision for the container.
+// start and exec reuse the enforcement decision stored on create if any.
+func (r *strictSupplementalGroupsRuntime) getEnforcementDecision(
+	logger zerolog.Logger,
+	crArgs *RuntimeArgs,
+	ctrInfo *bundle.ContainerInfo,
+) (*enforcementDecision, error) {
+	if crArgs.Command == CommandStart || crArgs.Command == CommandExec {
+		decision, err := r.loadEnforcementDecision(crArgs.Options.Root, crArgs.ContainerId)
+		switch {
+		case err != nil:
+			logger.Debug().Err(err).Msg("Failed to load enforcement decision. Get the pod from kubelet")
+		case decision.Pod == nil || decision.PodUID != ctrInfo.PodUID ||
+			decision.Pod.Namespace != ctrInfo.PodNamespace || decision.Pod.Name != ctrInfo.PodName ||
+			decision.ContainerName != ctrInfo.ContainerName:
+			logger.Warn().Interface("Decision", decision).Msg("Enforcement decision is for another container. Get the pod from kubelet")
+		case decision.AllowedGids == nil:
+			// saved by an older version
+			logger.Info().Str("PodUID", decision.PodUID).Msg("Enforcement decision has no allowed gids. Recompute them")
+			return r.newEnforcementDecision(logger, decision.Pod, decision.ContainerName, decision.Action)
+		default:
+			logger.Info().
+				Str("PodUID", decision.PodUID).
+				Str("EnforcementAction", string(decision.Action)).
+				Ints64("AllowedGids", decision.AllowedGids).
+				Msg("Enforcement decision loaded")
+			return decision, nil
+		}
+	}
+
+	action, namespacePolicy := r.getEnforcementAction(ctrInfo.PodNamespace)
+	logger.Info().Str("EnforcementAction", string(action)).Interface("NamespacePolicy", namespacePolicy).Msg("Enforcement action resolved")
+
+	pod, err := r.getPod(logger, ctrInfo)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get pod: %v", err)
+	}
+	return r.newEnforcementDecision(logger, pod, ctrInfo.ContainerName, action)
+}
+
+// newEnforcementDecision makes the enforcement decision for the container in the pod
+func (r *strictSupplementalGroupsRuntime) newEnforcementDecision(
+	logger zerolog.Logger,
+	pod *kubelet.Pod,
+	containerName string,
+	action config.EnforcementAction,
+) (*enforcementDecision, error) {
+	allowedGidSet, err := r.getAllowedGids(logger, pod, action)
+	if err != nil {
+		return nil, err
+	}
+	allowedGids := []int64{}
+	for gid := range allowedGidSet {
+		allowedGids = append(allowedGids, gid)
+	}
+	sort.Slice(allowedGids, func(i, j int) bool { return allowedGids[i] < allowedGids[j] })
+	return &enforcementDecision{
+		PodUID:        string(pod.UID),
+		ContainerName: containerName,
+		Action:        action,
+		AllowedGids:   allowedGids,
+		Pod:           pod,
+	}, nil
+}
+
+// getPod gets the pod of the container from kubelet through the pod cache if configured.  The pod (or
+// the container) not found yet and transient network errors are retried according to pod-lookup config.
+// The kubelet client is not created when the pod is found in the pod cache.
+func (r *strictSupplementalGroupsRuntime) getPod(logger zerolog.Logger, ctrInfo *bundle.ContainerInfo) (*kubelet.Pod, error) {
+	return kubelet.GetPodWithRetry(logger, r.cfg.PodLookup, func(ctx context.Context) (*kubelet.Pod, error) {
+		pod, err := r.getPodOnce(ctx, ctrInfo)
+		if err != nil {
+			return nil, err
+		}
+		// e.g. the ephemeral container is not in the pod yet
+		if ctrInfo.ContainerName != "" && !pod.HasContainer(ctrInfo.ContainerName) {
+			return nil, &kubelet.NotFoundError{Namespace: pod.Namespace, Name: pod.Name, Container: ctrInfo.ContainerName}
+		}
+		return pod, nil
+	})
+}
+
+func (r *strictSupplementalGroupsRuntime) getPodOnce(ctx context.Context, ctrInfo *bundle.ContainerInfo) (*kubelet.Pod, error) {
+	if r.cfg.PodCache.File == "" {
+		kubeletClient, err := r.getKubeletClient()
+		if err != nil {
+			return nil, err
+		}
+		return kubeletClient.Pod(ctx, ctrInfo.PodNamespace, ctrInfo.PodName, ctrInfo.PodUID)
+	}
+	podCache := kubelet.NewPodCache(r.cfg)
+	return podCache.Pod(ctrInfo.PodNamespace, ctrInfo.PodName, ctrInfo.PodUID, ctrInfo.ContainerName, func() ([]*kubelet.Pod, error) {
+		kubeletClient, err := r.getKubeletClient()
+		if err != nil {
+			return nil, err
+		}
+		return kubeletClient.Pods(ctx)
+	})
+}
+
+// saveEnforcementDecision stores the enforcement decision for the container.  Failures are only logged
+// because start and exec can get the pod from kubelet.
+func (r *strictSupplementalGroupsRuntime) saveEnforcementDecision(
+	logger zerolog.Logger,
+	crArgs *RuntimeArgs,
+	decision *enforcementDecision,
+) {
+	if r.cfg.EnforcementDecisionDir == "" {
+		return
+	}
+
+	saved := *decision
+	saved.Pod = decision.Pod.SecuritySubset()
+	if err := r.writeEnforcementDecision(crArgs.Options.Root, crArgs.ContainerId, &saved); err != nil {
+		logger.Warn().Err(err).Msg("Failed to save enforcement decision. Ignored.")
+		return
+	}
+	logger.Debug().Interface("Decision", saved).Msg("Enforcement decision saved")
+}
+
+// deleteEnforcementDecision removes the enforcement decision of the deleted container
+func (r *strictSupplementalGroupsRuntime) deleteEnforcementDecision(logger zerolog.Logger, crArgs *RuntimeArgs) {
+	if r.cfg.EnforcementDecisionDir == "" {
+		return
+	}
+	decisionFile, err := r.enforcementDecisionFile(crArgs.Options.Root, crArgs.ContainerId)
+	if err != nil {
+		logger.Warn().Err(err).Msg("Failed to delete enforcement decision. Ignored.")
+		return
+	}
+	if err := os.Remove(decisionFile); err != nil && !os.IsNotExist(err) {
+		logger.Warn().Err(err).Msg("Failed to delete enforcement decision. Ignored.")
+		return
+	}
+	logger.Debug().Str("DecisionFile", decisionFile).Msg("Enforcement decision deleted")
+}
+
+func (r *strictSupplementalGroupsRuntime) loadEnforcementDecision(root, containerId string) (*enforcementDecision, error) {
+	if r.cfg.EnforcementDecisionDir == "" {
+		return nil, fmt.Errorf("enforcement-decision-dir is not configured")
+	}
+	decisionFile, err := r.enforcementDecisionFile(root, containerId)
+	if err != nil {
+		return nil, err
+	}
+	decisionRaw, err := os.ReadFile(decisionFile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read enforcement decision: %v", err)
+	}
+	var decision enforcementDecision
+	if err := json.Unmarshal(decisionRaw, &decision); err != nil {
+		return nil, fmt.Errorf("Failed to parse enforcement decision %s: %v", decisionFile, err)
+	}
+	return &decision, nil
+}
+
+func (r *strictSupplementalGroupsRuntime) writeEnforcementDecision(root, containerId string, decision *enforcementDecision) error {
+	decisionFile, err := r.enforcementDecisionFile(root, containerId)
+	if err != nil {
+		return err
+	}
+	decisionRaw, err := json.Marshal(decision)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal enforcement decision: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(decisionFile), 0700); err != nil {
+		return fmt.Errorf("Failed to create enforcement decision directory: %v", err)
+	}
+
+	if err := atomicfile.WriteFile(decisionFile, decisionRaw); err != nil {
+		return fmt.Errorf("Failed to write enforcement decision file: %v", err)
+	}
+	return nil
+}
+
+// enforcementDecisionFile returns the path of the enforcement decision file.  Decisions are separated
+// per root directory of the runtime because container ids are unique only in the root directory.
+func (r *strictSupplementalGroupsRuntime) enforcementDecisionFile(root, containerId string) (string, error) {
+	if !isValidContainerId(containerId) {
+		return "", fmt.Errorf("invalid container id %q", containerId)
+	}
+	rootHash := sha256.Sum256([]byte(r.runtimeRoot(root)))
+	return filepath.Join(r.cfg.EnforcementDecisionDir, hex.EncodeToString(rootHash[:8]), containerId+".json"), nil
+}
+
+// runtimeRoot returns the root directory of the runtime's state
+func (r *strictSupplementalGroupsRuntime) runtimeRoot(root string) string {
+	if root == "" {
+		return r.cfg.ResolvedRuntimeProfile.DefaultRoot
+	}
+	return root
+}
+
+// isValidContainerId reports whether the container id can be used as a file name
+func isValidContainerId(containerId string) bool {
+	return containerId != "" && containerId != "." && containerId != ".." &&
+		!strings.ContainsRune(containerId, filepath.Separator)
+}
@@ -0,0 +1,168 @@
+package runtime
+
+import (
+	"fmt"
+	"os"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	zlog "github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/pointer"
+
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/config"
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/kubelet"
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/oci/bundle"
+)
+
+// fakeRuntime records the command lines run as child processes
+type fakeRuntime struct {
+	err error
+	ran [][]string
+}
+
+func (f *fakeRuntime) Exec(args []string) error {
+	return fmt.Errorf("unexpected exec: %v", args)
+}
+
+func (f *fakeRuntime) Run(args []string) error {
+	f.ran = append(f.ran, args)
+	return f.err
+}
+
+var _ = Describe("enforcementDecision", func() {
+	root := "/run/containerd/runc/k8s.io"
+	ctrInfo := &bundle.ContainerInfo{PodNamespace: "user-alice", PodName: "pod", PodUID: "uid-1", ContainerName: "main"}
+	pod := &kubelet.Pod{Pod: corev1.Pod{
+		Spec: corev1.PodSpec{
+			SecurityContext: &corev1.PodSecurityContext{
+				SupplementalGroups: []int64{2000, 1000},
+				FSGroup:            pointer.Int64(3000),
+			},
+			Containers: []corev1.Container{{Name: "main", Image: "busybox"}},
+		},
+	}}
+	pod.Namespace, pod.Name, pod.UID = "user-alice", "pod", "uid-1"
+
+	var r *strictSupplementalGroupsRuntime
+	BeforeEach(func() {
+		// kubeletClient is nil so that getting the pod from kubelet fails the test
+		r = &strictSupplementalGroupsRuntime{cfg: &config.Config{EnforcementDecisionDir: GinkgoT().TempDir()}}
+	})
+	newDecision := func(action config.EnforcementAction) *enforcementDecision {
+		decision, err := r.newEnforcementDecision(zlog.Logger, pod, ctrInfo.ContainerName, action)
+		Expect(err).NotTo(HaveOccurred())
+		return decision
+	}
+
+	It("is saved on create, reused by start and exec, and deleted on delete", func() {
+		createArgs := &RuntimeArgs{Command: CommandCreate, ContainerId: testContainerId, Options: RuntimeOpts{Root: root}}
+		r.saveEnforcementDecision(zlog.Logger, createArgs, newDecision(config.EnforcementActionDeny))
+
+		decision, err := r.loadEnforcementDecision(root, testContainerId)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(decision.PodUID).To(Equal("uid-1"))
+		Expect(decision.Action).To(Equal(config.EnforcementActionDeny))
+		Expect(decision.AllowedGids).To(Equal([]int64{1000, 2000, 3000}))
+		Expect(decision.Pod.Spec.Containers[0].Image).To(BeEmpty())
+
+		for _, command := range []Command{CommandStart, CommandExec} {
+			crArgs := &RuntimeArgs{Command: command, ContainerId: testContainerId, Options: RuntimeOpts{Root: root}}
+			cached, err := r.getEnforcementDecision(zlog.Logger, crArgs, ctrInfo)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cached.Action).To(Equal(config.EnforcementActionDeny))
+			Expect(cached.AllowedGids).To(Equal([]int64{1000, 2000, 3000}))
+			Expect(cached.Pod.Spec.SecurityContext).To(Equal(pod.Spec.SecurityContext))
+		}
+
+		r.deleteEnforcementDecision(zlog.Logger, &RuntimeArgs{Command: CommandDelete, ContainerId: testContainerId, Options: RuntimeOpts{Root: root}})
+		_, err = r.loadEnforcementDecision(root, testContainerId)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("is kept when the runtime fails to delete the container", func() {
+		createArgs := &RuntimeArgs{Command: CommandCreate, ContainerId: testContainerId, Options: RuntimeOpts{Root: root}}
+		r.saveEnforcementDecision(zlog.Logger, createArgs, newDecision(config.EnforcementActionDrop))
+
+		deleteArgs := &RuntimeArgs{Command: CommandDelete, ContainerId: testContainerId, Options: RuntimeOpts{Root: root}}
+		args := []string{"runc", "--root", root, "delete", testContainerId}
+		r.underlyingRuntime = &fakeRuntime{err: fmt.Errorf("container is running")}
+		Expect(r.deleteContainer(zlog.Logger, deleteArgs, args)).NotTo(Succeed())
+		_, err := r.loadEnforcementDecision(root, testContainerId)
+		Expect(err).NotTo(HaveOccurred())
+
+		runtime := &fakeRuntime{}
+		r.underlyingRuntime = runtime
+		Expect(r.deleteContainer(zlog.Logger, deleteArgs, args)).To(Succeed())
+		Expect(runtime.ran).To(Equal([][]string{args}))
+		_, err = r.loadEnforcementDecision(root, testContainerId)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("is not reused for the pod recreated with the same name", func() {
+		createArgs := &RuntimeArgs{Command: CommandCreate, ContainerId: testContainerId, Options: RuntimeOpts{Root: root}}
+		r.saveEnforcementDecision(zlog.Logger, createArgs, newDecision(config.EnforcementActionDeny))
+
+		recreated := *ctrInfo
+		recreated.PodUID = "uid-2"
+		r.cfg.KubeConfig = "/nonexistent/kubelet.conf"
+		startArgs := &RuntimeArgs{Command: CommandStart, ContainerId: testContainerId, Options: RuntimeOpts{Root: root}}
+		_, err := r.getEnforcementDecision(zlog.Logger, startArgs, &recreated)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("enforces the allowed gids of the decision on start and exec", func() {
+		createArgs := &RuntimeArgs{Command: CommandCreate, ContainerId: testContainerId, Options: RuntimeOpts{Root: root}}
+		decision := newDecision(config.EnforcementActionDrop)
+		// e.g. the entitlement of the namespace had allowed only 1000 on create
+		decision.AllowedGids = []int64{1000}
+		r.saveEnforcementDecision(zlog.Logger, createArgs, decision)
+
+		execArgs := &RuntimeArgs{Command: CommandExec, ContainerId: testContainerId, Options: RuntimeOpts{Root: root}}
+		cached, err := r.getEnforcementDecision(zlog.Logger, execArgs, ctrInfo)
+		Expect(err).NotTo(HaveOccurred())
+		process := specs.Process{User: specs.User{UID: 1000, GID: 1000, AdditionalGids: []uint32{1000, 2000}}}
+		Expect(r.enforceSupplementalGroupsOnProcessSpec(zlog.Logger, &process, cached)).To(BeTrue())
+		Expect(process.User.AdditionalGids).To(Equal([]uint32{1000}))
+	})
+
+	It("recomputes the allowed gids of the decision saved without them", func() {
+		createArgs := &RuntimeArgs{Command: CommandCreate, ContainerId: testContainerId, Options: RuntimeOpts{Root: root}}
+		decision := newDecision(config.EnforcementActionDrop)
+		decision.AllowedGids = nil
+		r.saveEnforcementDecision(zlog.Logger, createArgs, decision)
+
+		startArgs := &RuntimeArgs{Command: CommandStart, ContainerId: testContainerId, Options: RuntimeOpts{Root: root}}
+		cached, err := r.getEnforcementDecision(zlog.Logger, startArgs, ctrInfo)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cached.AllowedGids).To(Equal([]int64{1000, 2000, 3000}))
+	})
+
+	It("is separated per root directory", func() {
+		createArgs := &RuntimeArgs{Command: CommandCreate, ContainerId: testContainerId, Options: RuntimeOpts{Root: root}}
+		r.saveEnforcementDecision(zlog.Logger, createArgs, newDecision(config.EnforcementActionDrop))
+
+		_, err := r.loadEnforcementDecision("/run/containerd/runc/moby", testContainerId)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("is not saved when disabled", func() {
+		r.cfg.EnforcementDecisionDir = ""
+		createArgs := &RuntimeArgs{Command: CommandCreate, ContainerId: testContainerId, Options: RuntimeOpts{Root: root}}
+		r.saveEnforcementDecision(zlog.Logger, createArgs, newDecision(config.EnforcementActionDrop))
+
+		_, err := r.loadEnforcementDecision(root, testContainerId)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("is not saved for invalid container ids", func() {
+		createArgs := &RuntimeArgs{Command: CommandCreate, ContainerId: "../escape", Options: RuntimeOpts{Root: root}}
+		r.saveEnforcementDecision(zlog.Logger, createArgs, newDecision(config.EnforcementActionDrop))
+
+		entries, err := os.ReadDir(r.cfg.EnforcementDecisionDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(BeEmpty())
+	})
+})
@@ -0,0 +1,204 @@
+package runtime
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/rs/zerolog"
+
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/oci/bundle"
+)
+
+// enforceSupplementalGroupsOnExecArgs enforces on the process given by "--user", "--additional-gids", "--cap" and
+// "--no-new-privs" options of "exec" command.  Violations are fixed by rewriting the options in the command line.
+// Violations which can not be fixed by the options (e.g. removing the container's additionalGids) are rejected.
+func (r *strictSupplementalGroupsRuntime) enforceSupplementalGroupsOnExecArgs(
+	logger zerolog.Logger,
+	b *bundle.Bundle,
+	crArgs *RuntimeArgs,
+	args []string,
+	ctrInfo *bundle.ContainerInfo,
+) ([]string, error) {
+	opts := crArgs.Options
+	if opts.User == "" && len(opts.AdditionalGids) == 0 && len(opts.Caps) == 0 && opts.NoNewPrivs == nil {
+		// the process runs as the container's process which was enforced on create
+		logger.Info().Msg("No process option is specified in command line. Skip to enforce supplementalGroups")
+		return args, nil
+	}
+
+	// the process defaults to the one of the container
+	var baseProcess specs.Process
+	_ = b.DoSpec(func(s *specs.Spec) error {
+		if s.Process != nil {
+			baseProcess = *s.Process
+		}
+		return nil
+	})
+	process, err := getExecProcess(baseProcess, opts)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug().Interface("User", process.User).Interface("Capabilities", process.Capabilities).Msg("Process is parsed from command line")
+
+	decision, err := r.getEnforcementDecision(logger, crArgs, ctrInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	enforced, err := r.enforceSupplementalGroupsOnProcessSpec(logger, &process, decision)
+	if err != nil {
+		return nil, err
+	}
+	if r.hardenProcessSpec(logger, &process, decision.Action) {
+		enforced = true
+	}
+	if !enforced {
+		return args, nil
+	}
+
+	userArgs, err := execUserArgs(baseProcess.User, process.User)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to rewrite exec command line: %w", err)
+	}
+	capArgs, err := execCapArgs(baseProcess.Capabilities, process.Capabilities, opts.Caps)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to rewrite exec command line: %w", err)
+	}
+	replacement := append(userArgs, capArgs...)
+	if opts.NoNewPrivs != nil || process.NoNewPrivileges != baseProcess.NoNewPrivileges {
+		replacement = append(replacement, fmt.Sprintf("--no-new-privs=%t", process.NoNewPrivileges))
+	}
+	rewritten, err := ReplaceCommandFlags(args, r.cfg.ResolvedRuntimeProfile.Flags, []string{"user", "additional-gids", "cap", "no-new-privs"}, replacement)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to rewrite exec command line: %w", err)
+	}
+	logger.Info().Strs("RewrittenCommand", rewritten).Msg("SupplementalGroups enforced successfully")
+	return rewritten, nil
+}
+
+// getExecProcess returns the process executed by "exec" command in the same way as runc:
+// the user is given by getExecUser, "--cap" are appended to the container's bounding, effective, permitted and
+// ambient capabilities and "--no-new-privs" overrides noNewPrivileges.
+func getExecProcess(baseProcess specs.Process, opts RuntimeOpts) (specs.Process, error) {
+	process := specs.Process{NoNewPrivileges: baseProcess.NoNewPrivileges}
+	user, err := getExecUser(baseProcess.User, opts)
+	if err != nil {
+		return process, err
+	}
+	process.User = user
+
+	if baseProcess.Capabilities != nil || len(opts.Caps) > 0 {
+		caps := specs.LinuxCapabilities{}
+		if baseProcess.Capabilities != nil {
+			caps = *baseProcess.Capabilities
+		}
+		copyCaps := func(base []string) []string {
+			return append(append([]string{}, base...), opts.Caps...)
+		}
+		process.Capabilities = &specs.LinuxCapabilities{
+			Bounding:    copyCaps(caps.Bounding),
+			Effective:   copyCaps(caps.Effective),
+			Permitted:   copyCaps(caps.Permitted),
+			Inheritable: append([]string{}, caps.Inheritable...),
+			Ambient:     copyCaps(caps.Ambient),
+		}
+	}
+	if opts.NoNewPrivs != nil {
+		process.NoNewPrivileges = *opts.NoNewPrivs
+	}
+	return process, nil
+}
+
+// getExecUser returns the user of the process executed by "exec" command in the same way as runc:
+// "--user uid[:gid]" overrides uid/gid of the container's user and "--additional-gids" are appended to its additionalGids.
+func getExecUser(baseUser specs.User, opts RuntimeOpts) (specs.User, error) {
+	user := specs.User{
+		UID:            baseUser.UID,
+		GID:            baseUser.GID,
+		AdditionalGids: append([]uint32{}, baseUser.AdditionalGids...),
+	}
+	if opts.User != "" {
+		ids := strings.SplitN(opts.User, ":", 2)
+		uid, err := strconv.ParseUint(ids[0], 10, 32)
+		if err != nil {
+			return user, fmt.Errorf("Failed to parse uid in --user %q: %v", opts.User, err)
+		}
+		user.UID = uint32(uid)
+		if len(ids) > 1 {
+			gid, err := strconv.ParseUint(ids[1], 10, 32)
+			if err != nil {
+				return user, fmt.Errorf("Failed to parse gid in --user %q: %v", opts.User, err)
+			}
+			user.GID = uint32(gid)
+		}
+	}
+	for _, gidStr := range opts.AdditionalGids {
+		gid, err := strconv.ParseUint(gidStr, 10, 32)
+		if err != nil {
+			return user, fmt.Errorf("Failed to parse --additional-gids %q: %v", gidStr, err)
+		}
+		user.AdditionalGids = append(user.AdditionalGids, uint32(gid))
+	}
+	return user, nil
+}
+
+// execUserArgs returns "exec" command options which make the process run as user.
+// Because runc appends "--additional-gids" to the container's additionalGids, those cannot be removed.
+func execUserArgs(baseUser, user specs.User) ([]string, error) {
+	gids := map[uint32]struct{}{}
+	for _, gid := range user.AdditionalGids {
+		gids[gid] = struct{}{}
+	}
+	baseGids := map[uint32]struct{}{}
+	missingGids := []uint32{}
+	for _, gid := range baseUser.AdditionalGids {
+		baseGids[gid] = struct{}{}
+		if _, ok := gids[gid]; !ok {
+			missingGids = append(missingGids, gid)
+		}
+	}
+	if len(missingGids) > 0 {
+		return nil, fmt.Errorf("additionalGids %v of the container cannot be removed by command line options", missingGids)
+	}
+
+	args := []string{"--user", fmt.Sprintf("%d:%d", user.UID, user.GID)}
+	for _, gid := range user.AdditionalGids {
+		if _, ok := baseGids[gid]; ok {
+			continue
+		}
+		args = append(args, "--additional-gids", strconv.FormatUint(uint64(gid), 10))
+	}
+	return args, nil
+}
+
+// execCapArgs returns "exec" command options which add capabilities in requested kept in caps.
+// Because runc appends "--cap" to the container's capabilities, those cannot be removed.
+func execCapArgs(baseCaps, caps *specs.LinuxCapabilities, requested []string) ([]string, error) {
+	kept := map[string]struct{}{}
+	if caps != nil {
+		for _, c := range caps.Bounding {
+			kept[c] = struct{}{}
+		}
+	}
+	if baseCaps != nil {
+		missingCaps := []string{}
+		for _, c := range baseCaps.Bounding {
+			if _, ok := kept[c]; !ok {
+				missingCaps = append(missingCaps, c)
+			}
+		}
+		if len(missingCaps) > 0 {
+			return nil, fmt.Errorf("capabilities %v of the container cannot be removed by command line options", missingCaps)
+		}
+	}
+
+	args := []string{}
+	for _, c := range requested {
+		if _, ok := kept[c]; ok {
+			args = append(args, "--cap", c)
+		}
+	}
+	return args, nil
+}
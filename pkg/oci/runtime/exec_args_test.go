@@ -0,0 +1,175 @@
+package runtime
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	zlog "github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/pointer"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/config"
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/kubelet"
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/oci/bundle"
+)
+
+var _ = Describe("exec command line", func() {
+	baseUser := specs.User{UID: 1000, GID: 1000, AdditionalGids: []uint32{2000}}
+
+	DescribeTable("getExecUser",
+		func(opts RuntimeOpts, expected specs.User) {
+			user, err := getExecUser(baseUser, opts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(user).To(Equal(expected))
+		},
+		Entry("no options", RuntimeOpts{},
+			specs.User{UID: 1000, GID: 1000, AdditionalGids: []uint32{2000}},
+		),
+		Entry("uid only", RuntimeOpts{User: "0"},
+			specs.User{UID: 0, GID: 1000, AdditionalGids: []uint32{2000}},
+		),
+		Entry("uid and gid", RuntimeOpts{User: "0:0"},
+			specs.User{UID: 0, GID: 0, AdditionalGids: []uint32{2000}},
+		),
+		Entry("additional gids are appended", RuntimeOpts{User: "1000:1000", AdditionalGids: []string{"3000", "4000"}},
+			specs.User{UID: 1000, GID: 1000, AdditionalGids: []uint32{2000, 3000, 4000}},
+		),
+	)
+
+	It("rejects non numeric ids", func() {
+		_, err := getExecUser(baseUser, RuntimeOpts{User: "root"})
+		Expect(err).To(HaveOccurred())
+		_, err = getExecUser(baseUser, RuntimeOpts{AdditionalGids: []string{"wheel"}})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("execUserArgs adds only additional gids not in the container's user", func() {
+		args, err := execUserArgs(baseUser, specs.User{UID: 1000, GID: 2000, AdditionalGids: []uint32{2000, 3000}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(args).To(Equal([]string{"--user", "1000:2000", "--additional-gids", "3000"}))
+	})
+
+	It("execUserArgs fails when additional gids of the container's user are removed", func() {
+		_, err := execUserArgs(baseUser, specs.User{UID: 1000, GID: 1000, AdditionalGids: []uint32{3000}})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("enforceSupplementalGroupsOnExecArgs", func() {
+	bin := "strict-supplementalgroups-container-runtime"
+	root := "/run/containerd/runc/k8s.io"
+	ctrInfo := &bundle.ContainerInfo{PodNamespace: "user-alice", PodName: "pod", PodUID: "uid-1", ContainerName: "main"}
+	pod := &kubelet.Pod{Pod: corev1.Pod{
+		Spec: corev1.PodSpec{
+			SecurityContext: &corev1.PodSecurityContext{
+				RunAsUser:          pointer.Int64(1000),
+				RunAsGroup:         pointer.Int64(1000),
+				SupplementalGroups: []int64{2000, 3000},
+			},
+			Containers: []corev1.Container{{Name: "main"}},
+		},
+	}}
+	pod.Namespace, pod.Name, pod.UID = "user-alice", "pod", "uid-1"
+
+	// the container's process enforced and hardened on create
+	newBundle := func() *bundle.Bundle {
+		dir := GinkgoT().TempDir()
+		spec := specs.Spec{Process: &specs.Process{
+			User:            specs.User{UID: 1000, GID: 1000, AdditionalGids: []uint32{2000}},
+			Capabilities:    &specs.LinuxCapabilities{Bounding: []string{"CAP_CHOWN"}, Effective: []string{"CAP_CHOWN"}},
+			NoNewPrivileges: true,
+		}}
+		specRaw, err := json.Marshal(&spec)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.WriteFile(filepath.Join(dir, "config.json"), specRaw, 0644)).To(Succeed())
+		b, err := bundle.NewBundle(dir)
+		Expect(err).NotTo(HaveOccurred())
+		return b
+	}
+	newRuntime := func(action config.EnforcementAction) *strictSupplementalGroupsRuntime {
+		r := &strictSupplementalGroupsRuntime{cfg: &config.Config{
+			EnforcementDecisionDir: GinkgoT().TempDir(),
+			PrimaryGroupPolicy:     config.PrimaryGroupPolicyIgnore,
+			Capabilities: config.CapabilitiesConfig{
+				Policy:       config.CapabilitiesPolicyDrop,
+				Capabilities: []string{"CAP_SETGID", "CAP_SETUID", "CAP_SYS_ADMIN"},
+			},
+			Hardening: config.HardeningConfig{NoNewPrivileges: true},
+		}}
+		// the pod is loaded from the enforcement decision saved on create
+		createArgs := &RuntimeArgs{Command: CommandCreate, ContainerId: testContainerId, Options: RuntimeOpts{Root: root}}
+		decision, err := r.newEnforcementDecision(zlog.Logger, pod, ctrInfo.ContainerName, action)
+		Expect(err).NotTo(HaveOccurred())
+		r.saveEnforcementDecision(zlog.Logger, createArgs, decision)
+		return r
+	}
+
+	DescribeTable("rewrites the command line",
+		func(action config.EnforcementAction, execOptions []string, expectedOptions []string, expectErr bool) {
+			args := append(append([]string{bin, "--root", root, "exec"}, execOptions...), testContainerId, "id", "-G")
+			crArgs, err := GetRuntimeArgs(args)
+			Expect(err).NotTo(HaveOccurred())
+
+			rewritten, err := newRuntime(action).enforceSupplementalGroupsOnExecArgs(zlog.Logger, newBundle(), crArgs, args, ctrInfo)
+			if expectErr {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rewritten).To(Equal(append(append([]string{bin, "--root", root, "exec"}, expectedOptions...), testContainerId, "id", "-G")))
+		},
+		Entry("drop drops capabilities given without user options",
+			config.EnforcementActionDrop,
+			[]string{"--cap", "CAP_SYS_ADMIN", "--tty"},
+			[]string{"--user", "1000:1000", "--tty"},
+			false,
+		),
+		Entry("drop drops capabilities and gids and keeps noNewPrivileges",
+			config.EnforcementActionDrop,
+			[]string{"--cap", "CAP_SETGID", "-c", "CAP_NET_RAW", "-g", "4000", "-g", "3000", "--no-new-privs=false"},
+			[]string{"--user", "1000:1000", "--additional-gids", "3000", "--cap", "CAP_NET_RAW", "--no-new-privs=true"},
+			false,
+		),
+		Entry("drop keeps allowed options as is",
+			config.EnforcementActionDrop,
+			[]string{"--cap", "CAP_NET_RAW", "-g", "3000"},
+			[]string{"--cap", "CAP_NET_RAW", "-g", "3000"},
+			false,
+		),
+		Entry("deny rejects capabilities",
+			config.EnforcementActionDeny,
+			[]string{"--cap", "CAP_SETUID"},
+			nil,
+			true,
+		),
+		Entry("deny rejects gids",
+			config.EnforcementActionDeny,
+			[]string{"-g", "4000"},
+			nil,
+			true,
+		),
+		Entry("audit keeps the command line",
+			config.EnforcementActionAudit,
+			[]string{"--cap", "CAP_SETGID", "-g", "4000", "--no-new-privs=false"},
+			[]string{"--cap", "CAP_SETGID", "-g", "4000", "--no-new-privs=false"},
+			false,
+		),
+	)
+
+	It("sets noNewPrivileges with seccomp hardening", func() {
+		args := []string{bin, "--root", root, "exec", "--no-new-privs=false", testContainerId, "id", "-G"}
+		crArgs, err := GetRuntimeArgs(args)
+		Expect(err).NotTo(HaveOccurred())
+
+		r := newRuntime(config.EnforcementActionDrop)
+		r.cfg.Hardening = config.HardeningConfig{Seccomp: true}
+		rewritten, err := r.enforceSupplementalGroupsOnExecArgs(zlog.Logger, newBundle(), crArgs, args, ctrInfo)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rewritten).To(Equal([]string{bin, "--root", root, "exec", "--user", "1000:1000", "--no-new-privs=true", testContainerId, "id", "-G"}))
+	})
+})
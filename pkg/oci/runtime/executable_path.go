@@ -1,6 +1,9 @@
 package runtime
 
 import (
+	"os"
+	"os/exec"
+
 	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/lookup"
 )
 
@@ -9,6 +12,10 @@ type executablePathRuntime struct {
 }
 
 func NewExecutablePathRuntime(path string) (Interface, error) {
+	return newExecutablePathRuntime(path)
+}
+
+func newExecutablePathRuntime(path string) (*executablePathRuntime, error) {
 	runtimePath, err := lookup.LookupExecutable(path)
 	if err != nil {
 		return nil, err
@@ -25,3 +32,15 @@ func (r *executablePathRuntime) Exec(args []string) error {
 	}
 	return SyscallExecRuntime.Exec(execArgs)
 }
+
+// Run runs the runtime as a child process sharing stdio with this process and waits for it to exit.
+// *exec.ExitError is returned when the runtime exits with non-zero status.
+func (r *executablePathRuntime) Run(args []string) error {
+	runArgs := []string{}
+	if len(args) > 1 {
+		runArgs = args[1:]
+	}
+	cmd := exec.Command(r.path, runArgs...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,131 @@
+package runtime
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/rs/zerolog"
+
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/config"
+)
+
+// hardenSpec applies hardening options to OCI spec of the enforced container
+func (r *strictSupplementalGroupsRuntime) hardenSpec(
+	logger zerolog.Logger,
+	spec *specs.Spec,
+	action config.EnforcementAction,
+) (bool /* hardening performed or not*/, error) {
+	hardened := r.hardenProcessSpec(logger, spec.Process, action)
+
+	nosuidMounts := []string{}
+	for i := range spec.Mounts {
+		m := &spec.Mounts[i]
+		if !isBindMount(m) || !r.isUnderNosuidHostPaths(m.Source) || hasMountOption(m, "nosuid") {
+			continue
+		}
+		nosuidMounts = append(nosuidMounts, m.Destination)
+		if action == config.EnforcementActionAudit {
+			continue
+		}
+		options := []string{}
+		for _, o := range m.Options {
+			if o != "suid" {
+				options = append(options, o)
+			}
+		}
+		m.Options = append(options, "nosuid")
+	}
+	if len(nosuidMounts) > 0 {
+		logger := logger.With().Strs("nosuidMounts", nosuidMounts).Strs("nosuidHostPaths", r.cfg.Hardening.NosuidHostPaths).Logger()
+		if action == config.EnforcementActionAudit {
+			logger.Warn().Msg("Detected bind mounts under nosuid host paths without nosuid. Not enforced because of audit action")
+		} else {
+			logger.Info().Msg("Detected bind mounts under nosuid host paths without nosuid. Adding nosuid")
+			hardened = true
+		}
+	}
+
+	if r.cfg.Hardening.Seccomp {
+		// the rules allow only the gids the process runs with after enforcement, not all the gids the pod is
+		// allowed to have, so that the process can not switch to allowed gids it has not been given.
+		allowedGids := processGids(spec.Process)
+		logger := logger.With().Interface("seccompAllowedGids", allowedGids).Logger()
+		if action == config.EnforcementActionAudit {
+			logger.Warn().Msg("Skipped merging seccomp rules denying syscalls changing gids because of audit action")
+		} else {
+			if spec.Linux == nil {
+				spec.Linux = &specs.Linux{}
+			}
+			seccomp, err := mergeSeccompGidRules(spec.Linux.Seccomp, allowedGids)
+			if err != nil {
+				return false, fmt.Errorf("Failed to merge seccomp rules restricting syscalls changing gids: %v", err)
+			}
+			spec.Linux.Seccomp = seccomp
+			logger.Info().Msg("Merged seccomp rules denying syscalls changing gids")
+			hardened = true
+		}
+	}
+
+	return hardened, nil
+}
+
+// processGids returns sorted gid and additionalGids of the process
+func processGids(processSpec *specs.Process) []uint32 {
+	set := map[uint32]struct{}{processSpec.User.GID: {}}
+	for _, gid := range processSpec.User.AdditionalGids {
+		set[gid] = struct{}{}
+	}
+	gids := []uint32{}
+	for gid := range set {
+		gids = append(gids, gid)
+	}
+	sort.Slice(gids, func(i, j int) bool { return gids[i] < gids[j] })
+	return gids
+}
+
+// hardenProcessSpec applies hardening options to the process spec of the enforced container.
+// noNewPrivileges is also set with seccomp hardening: without it runc installs the seccomp filter before setting up
+// the user of the process, so its own setgroups(2) would be denied by the merged rules and the process would fail to start.
+func (r *strictSupplementalGroupsRuntime) hardenProcessSpec(
+	logger zerolog.Logger,
+	processSpec *specs.Process,
+	action config.EnforcementAction,
+) bool /* hardening performed or not*/ {
+	if !(r.cfg.Hardening.NoNewPrivileges || r.cfg.Hardening.Seccomp) || processSpec.NoNewPrivileges {
+		return false
+	}
+	if action == config.EnforcementActionAudit {
+		logger.Warn().Msg("Detected noNewPrivileges=false. Not enforced because of audit action")
+		return false
+	}
+	logger.Info().Bool("seccomp", r.cfg.Hardening.Seccomp).Msg("Detected noNewPrivileges=false. Setting noNewPrivileges=true")
+	processSpec.NoNewPrivileges = true
+	return true
+}
+
+func (r *strictSupplementalGroupsRuntime) isUnderNosuidHostPaths(source string) bool {
+	source = filepath.Clean(source)
+	for _, hostPath := range r.cfg.Hardening.NosuidHostPaths {
+		hostPath = filepath.Clean(hostPath)
+		if source == hostPath || strings.HasPrefix(source, strings.TrimSuffix(hostPath, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func isBindMount(m *specs.Mount) bool {
+	return m.Type == "bind" || hasMountOption(m, "bind") || hasMountOption(m, "rbind")
+}
+
+func hasMountOption(m *specs.Mount, option string) bool {
+	for _, o := range m.Options {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
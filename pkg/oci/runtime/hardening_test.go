@@ -0,0 +1,57 @@
+package runtime
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	zlog "github.com/rs/zerolog/log"
+
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/config"
+)
+
+var _ = Describe("hardenSpec", func() {
+	r := strictSupplementalGroupsRuntime{cfg: &config.Config{
+		Hardening: config.HardeningConfig{
+			NoNewPrivileges: true,
+			NosuidHostPaths: []string{"/mnt/hostpath/"},
+		},
+	}}
+	newSpec := func() *specs.Spec {
+		return &specs.Spec{
+			Process: &specs.Process{},
+			Mounts: []specs.Mount{
+				{Destination: "/proc", Type: "proc", Source: "proc", Options: []string{"nosuid", "noexec", "nodev"}},
+				{Destination: "/mnt/nfs", Type: "bind", Source: "/mnt/hostpath", Options: []string{"rbind", "rprivate", "rw"}},
+				{Destination: "/mnt/private", Source: "/mnt/hostpath/bypassed-group-private", Options: []string{"rbind", "suid"}},
+				{Destination: "/mnt/other", Type: "bind", Source: "/mnt/hostpath2", Options: []string{"rbind"}},
+			},
+		}
+	}
+
+	It("sets noNewPrivileges and adds nosuid to bind mounts under nosuid host paths", func() {
+		spec := newSpec()
+		Expect(r.hardenSpec(zlog.Logger, spec, config.EnforcementActionDrop)).To(BeTrue())
+		Expect(spec.Process.NoNewPrivileges).To(BeTrue())
+		Expect(spec.Mounts[0].Options).To(Equal([]string{"nosuid", "noexec", "nodev"}))
+		Expect(spec.Mounts[1].Options).To(Equal([]string{"rbind", "rprivate", "rw", "nosuid"}))
+		Expect(spec.Mounts[2].Options).To(Equal([]string{"rbind", "nosuid"}))
+		Expect(spec.Mounts[3].Options).To(Equal([]string{"rbind"}))
+
+		// already hardened
+		Expect(r.hardenSpec(zlog.Logger, spec, config.EnforcementActionDrop)).To(BeFalse())
+	})
+
+	It("changes nothing in audit action", func() {
+		spec := newSpec()
+		Expect(r.hardenSpec(zlog.Logger, spec, config.EnforcementActionAudit)).To(BeFalse())
+		Expect(spec).To(Equal(newSpec()))
+	})
+
+	It("changes nothing when disabled", func() {
+		r := strictSupplementalGroupsRuntime{cfg: &config.Config{}}
+		spec := newSpec()
+		Expect(r.hardenSpec(zlog.Logger, spec, config.EnforcementActionDrop)).To(BeFalse())
+		Expect(spec).To(Equal(newSpec()))
+	})
+})
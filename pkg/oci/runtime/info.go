@@ -0,0 +1,106 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	zlog "github.com/rs/zerolog/log"
+
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/lookup"
+)
+
+const (
+	runtimeName = "strict-supplementalgroups-container-runtime"
+
+	// wrapperAnnotationPrefix is the prefix of annotations added to the output of "features" command
+	wrapperAnnotationPrefix = "io.github.pfnet-research.strict-supplementalgroups-container-runtime."
+)
+
+// printFeatures prints the output of the underlying runtime's "features" command with annotations describing this runtime
+func (r *strictSupplementalGroupsRuntime) printFeatures(args []string, stdout io.Writer) error {
+	featuresRaw, err := r.runUnderlyingRuntime(args[1:]...)
+	if err != nil {
+		return err
+	}
+	augmented, err := augmentFeatures(featuresRaw, r.wrapperAnnotations())
+	if err != nil {
+		return err
+	}
+	_, err = stdout.Write(augmented)
+	return err
+}
+
+// printVersion prints the version of this runtime followed by the underlying runtime's one
+func (r *strictSupplementalGroupsRuntime) printVersion(stdout io.Writer) error {
+	version, err := r.runUnderlyingRuntime("--version")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(stdout, "%s version %s\n%s", runtimeName, r.version, version)
+	return err
+}
+
+func (r *strictSupplementalGroupsRuntime) wrapperAnnotations() map[string]string {
+	annotations := map[string]string{
+		wrapperAnnotationPrefix + "version":            r.version,
+		wrapperAnnotationPrefix + "enforcement-action": string(r.cfg.Policy.DefaultAction),
+	}
+	if r.cfg.ConfigProfile != "" {
+		annotations[wrapperAnnotationPrefix+"config-profile"] = r.cfg.ConfigProfile
+	}
+	return annotations
+}
+
+// runUnderlyingRuntime runs the underlying runtime and returns its stdout.  stderr is passed through.
+func (r *strictSupplementalGroupsRuntime) runUnderlyingRuntime(args ...string) ([]byte, error) {
+	runtime, err := lookup.LookupExecutable(r.cfg.Runtime)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to find runtime: %v", err)
+	}
+	command := append([]string{runtime}, args...)
+	cmd := exec.Command(command[0], command[1:]...)
+	var stdout bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		zlog.Error().Err(err).Str("Stdout", stdout.String()).Strs("Command", command).Msg("Failed to execute Command")
+		return nil, fmt.Errorf("Failed to execute command '%s': %v", strings.Join(command, " "), err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// augmentFeatures adds annotations to the "features" JSON.  Other fields are kept intact.
+func augmentFeatures(featuresRaw []byte, annotations map[string]string) ([]byte, error) {
+	var features map[string]json.RawMessage
+	if err := json.Unmarshal(featuresRaw, &features); err != nil {
+		return nil, fmt.Errorf("Failed to parse features json: %v", err)
+	}
+
+	merged := map[string]string{}
+	if raw, ok := features["annotations"]; ok {
+		if err := json.Unmarshal(raw, &merged); err != nil {
+			return nil, fmt.Errorf("Failed to parse annotations in features json: %v", err)
+		}
+	}
+	for k, v := range annotations {
+		merged[k] = v
+	}
+	annotationsRaw, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	features["annotations"] = annotationsRaw
+
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "    ")
+	if err := enc.Encode(features); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,68 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/config"
+)
+
+var _ = Describe("features and version", func() {
+	var r *strictSupplementalGroupsRuntime
+	BeforeEach(func() {
+		runtime := filepath.Join(GinkgoT().TempDir(), "runc")
+		script := `#!/bin/sh
+case "$1" in
+--version) printf 'runc version 1.1.4\nspec: 1.0.2-dev\n' ;;
+features) echo '{"ociVersionMin":"1.0.0","hooks":["prestart"],"annotations":{"org.opencontainers.runc.version":"1.1.4"}}' ;;
+*) exit 1 ;;
+esac
+`
+		Expect(os.WriteFile(runtime, []byte(script), 0755)).To(Succeed())
+		r = &strictSupplementalGroupsRuntime{
+			cfg: &config.Config{
+				Runtime:       runtime,
+				ConfigProfile: "strict-crun",
+				Policy:        config.PolicyConfig{DefaultAction: config.EnforcementActionDeny},
+			},
+			version: "1.2.3",
+		}
+	})
+
+	It("augments features with the wrapper's annotations", func() {
+		var out bytes.Buffer
+		Expect(r.printFeatures([]string{"strict-supplementalgroups-container-runtime", "features"}, &out)).To(Succeed())
+
+		var features struct {
+			OCIVersionMin string            `json:"ociVersionMin"`
+			Hooks         []string          `json:"hooks"`
+			Annotations   map[string]string `json:"annotations"`
+		}
+		Expect(json.Unmarshal(out.Bytes(), &features)).To(Succeed())
+		Expect(features.OCIVersionMin).To(Equal("1.0.0"))
+		Expect(features.Hooks).To(Equal([]string{"prestart"}))
+		Expect(features.Annotations).To(Equal(map[string]string{
+			"org.opencontainers.runc.version":                                                         "1.1.4",
+			"io.github.pfnet-research.strict-supplementalgroups-container-runtime.version":            "1.2.3",
+			"io.github.pfnet-research.strict-supplementalgroups-container-runtime.enforcement-action": "deny",
+			"io.github.pfnet-research.strict-supplementalgroups-container-runtime.config-profile":     "strict-crun",
+		}))
+	})
+
+	It("adds annotations to features without annotations", func() {
+		augmented, err := augmentFeatures([]byte(`{"ociVersionMin":"1.0.0"}`), map[string]string{"key": "value"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(augmented).To(MatchJSON(`{"ociVersionMin":"1.0.0","annotations":{"key":"value"}}`))
+	})
+
+	It("prints versions of both runtimes", func() {
+		var out bytes.Buffer
+		Expect(r.printVersion(&out)).To(Succeed())
+		Expect(out.String()).To(Equal("strict-supplementalgroups-container-runtime version 1.2.3\nrunc version 1.1.4\nspec: 1.0.2-dev\n"))
+	})
+})
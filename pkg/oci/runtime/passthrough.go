@@ -0,0 +1,64 @@
+package runtime
+
+import (
+	"github.com/rs/zerolog"
+
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/config"
+)
+
+// enforcedCommands is the commands which start processes in containers.  Other commands are passed through
+// to the underlying runtime.
+var enforcedCommands = map[Command]struct{}{
+	CommandCreate:  {},
+	CommandRun:     {},
+	CommandRestore: {},
+	CommandStart:   {},
+	CommandExec:    {},
+}
+
+func isEnforcedCommand(command Command) bool {
+	_, ok := enforcedCommands[command]
+	return ok
+}
+
+// IsPassthrough reports whether the invocation can be passed through to the underlying runtime as is.
+// Such invocations (e.g. state, kill, events, ps) are invoked very frequently by CRI implementations, so
+// they should be executed with minimal work (i.e. no kubelet client, no log files).
+// Invocations failing to be parsed are not passed through so that the error is reported.
+func IsPassthrough(cfg *config.Config, args []string) bool {
+	crArgs, err := GetRuntimeArgsWithFlags(args, cfg.ResolvedRuntimeProfile.Flags)
+	if err != nil {
+		return false
+	}
+	if isEnforcedCommand(crArgs.Command) {
+		return false
+	}
+	switch crArgs.Command {
+	case CommandDelete:
+		// the enforcement decision must be deleted
+		return cfg.EnforcementDecisionDir == ""
+	case CommandFeatures:
+		// augmented with the wrapper's information
+		return false
+	case "":
+		// "--version" prints the wrapper's version too
+		return !crArgs.Options.Version
+	default:
+		return true
+	}
+}
+
+// NewPassthroughLogger returns the logger writing runc's log lines to the container log given by the command line
+// (--log and --log-format) as the enforced commands do, so that CRI implementations can report failures of
+// passed-through invocations.  Events are discarded when no log file is given.  The returned function closes the log file.
+func NewPassthroughLogger(cfg *config.Config, args []string) (zerolog.Logger, func() error, error) {
+	crArgs, err := GetRuntimeArgsWithFlags(args, cfg.ResolvedRuntimeProfile.Flags)
+	if err != nil {
+		return zerolog.Nop(), nil, err
+	}
+	clogWriter, closeContainerLogFile, err := createContainerLogWriter(crArgs)
+	if err != nil {
+		return zerolog.Nop(), nil, err
+	}
+	return zerolog.New(clogWriter).With().Timestamp().Logger(), closeContainerLogFile, nil
+}
@@ -0,0 +1,87 @@
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/config"
+)
+
+var _ = Describe("IsPassthrough", func() {
+	bin := "strict-supplementalgroups-container-runtime"
+	root := "/run/containerd/runc/k8s.io"
+	cfg := &config.Config{EnforcementDecisionDir: "/run/strict-supplementalgroups-container-runtime/decisions"}
+
+	DescribeTable("classifies commands",
+		func(args []string, expected bool) {
+			Expect(IsPassthrough(cfg, args)).To(Equal(expected))
+		},
+		Entry("create", []string{bin, "--root", root, "create", "--bundle", "/bundle", testContainerId}, false),
+		Entry("run", []string{bin, "--root", root, "run", "--bundle", "/bundle", testContainerId}, false),
+		Entry("restore", []string{bin, "--root", root, "restore", "--bundle", "/bundle", testContainerId}, false),
+		Entry("start", []string{bin, "--root", root, "start", testContainerId}, false),
+		Entry("exec", []string{bin, "--root", root, "exec", "--process", "/process.json", testContainerId}, false),
+		Entry("delete", []string{bin, "--root", root, "delete", "--force", testContainerId}, false),
+		Entry("state", []string{bin, "--root", root, "state", testContainerId}, true),
+		Entry("kill", []string{bin, "--root", root, "kill", testContainerId, "9"}, true),
+		Entry("events", []string{bin, "--root", root, "events", "--stats", testContainerId}, true),
+		Entry("ps", []string{bin, "--root", root, "ps", "--format", "json", testContainerId}, true),
+		Entry("features", []string{bin, "features"}, false),
+		Entry("version", []string{bin, "--version"}, false),
+		Entry("help", []string{bin, "--help"}, true),
+		Entry("invalid arguments", []string{bin, "--root"}, false),
+	)
+
+	It("passes through delete when the enforcement decision is not stored", func() {
+		cfg := &config.Config{}
+		Expect(IsPassthrough(cfg, []string{bin, "--root", root, "delete", testContainerId})).To(BeTrue())
+	})
+})
+
+// BenchmarkPassthroughStartup measures the work done before executing the underlying runtime
+// for passthrough commands (see "Startup latency" in README.md for the budget)
+func BenchmarkPassthroughStartup(b *testing.B) {
+	args := []string{
+		"strict-supplementalgroups-container-runtime",
+		"--root", "/run/containerd/runc/k8s.io",
+		"--log", "/run/containerd/io.containerd.runtime.v2.task/k8s.io/" + testContainerId + "/log.json",
+		"--log-format", "json",
+		"state", testContainerId,
+	}
+	for i := 0; i < b.N; i++ {
+		cfg, err := config.LoadConfig("")
+		if err != nil {
+			b.Fatal(err)
+		}
+		if !IsPassthrough(cfg, args) {
+			b.Fatal("state must be passed through")
+		}
+		if _, err := NewExecutablePathRuntime("sh"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+var _ = Describe("NewPassthroughLogger", func() {
+	It("writes runc's log lines to the container log", func() {
+		logFile := filepath.Join(GinkgoT().TempDir(), "log.json")
+		args := []string{"strict-supplementalgroups-container-runtime", "--log", logFile, "--log-format", "json", "state", testContainerId}
+		logger, closeLogFile, err := NewPassthroughLogger(&config.Config{}, args)
+		Expect(err).NotTo(HaveOccurred())
+		logger.Error().Err(fmt.Errorf("no such file or directory")).Msg("Failed to run container runtime")
+		Expect(closeLogFile()).To(Succeed())
+
+		logRaw, err := os.ReadFile(logFile)
+		Expect(err).NotTo(HaveOccurred())
+		var line map[string]interface{}
+		Expect(json.Unmarshal(logRaw, &line)).To(Succeed())
+		Expect(line).To(HaveKeyWithValue("level", "error"))
+		Expect(line).To(HaveKeyWithValue("msg", "Failed to run container runtime: no such file or directory"))
+	})
+})
@@ -0,0 +1,43 @@
+package runtime
+
+import (
+	"path"
+
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/config"
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/oci/bundle"
+)
+
+// getEnforcementAction returns the enforcement action for the namespace and the matched policy.
+// The returned policy is nil when the default action is used.
+func (r *strictSupplementalGroupsRuntime) getEnforcementAction(namespace string) (config.EnforcementAction, *config.NamespacePolicy) {
+	for i := range r.cfg.Policy.Namespaces {
+		p := &r.cfg.Policy.Namespaces[i]
+		if matchPattern(p.Namespace, namespace) {
+			return p.Action, p
+		}
+	}
+	return r.cfg.Policy.DefaultAction, nil
+}
+
+// findExemption returns the first exemption matching the container, or nil if not exempted.
+func (r *strictSupplementalGroupsRuntime) findExemption(ctrInfo *bundle.ContainerInfo) *config.Exemption {
+	for i := range r.cfg.Policy.Exemptions {
+		e := &r.cfg.Policy.Exemptions[i]
+		if matchPattern(e.Namespace, ctrInfo.PodNamespace) &&
+			matchPattern(e.Pod, ctrInfo.PodName) &&
+			matchPattern(e.Container, ctrInfo.ContainerName) {
+			return e
+		}
+	}
+	return nil
+}
+
+// matchPattern reports whether name matches the glob pattern. Empty pattern matches any.
+func matchPattern(pattern, name string) bool {
+	if pattern == "" {
+		return true
+	}
+	// patterns are validated when loading config
+	matched, _ := path.Match(pattern, name)
+	return matched
+}
@@ -0,0 +1,68 @@
+package runtime
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/config"
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/oci/bundle"
+)
+
+var _ = Describe("Policy", func() {
+	r := strictSupplementalGroupsRuntime{
+		cfg: &config.Config{
+			Policy: config.PolicyConfig{
+				DefaultAction: config.EnforcementActionDeny,
+				Namespaces: []config.NamespacePolicy{
+					{Namespace: "kube-system", Action: config.EnforcementActionAudit},
+					{Namespace: "user-*", Action: config.EnforcementActionDrop},
+					{Namespace: "*", Action: config.EnforcementActionAudit},
+				},
+				Exemptions: []config.Exemption{
+					{Namespace: "monitoring", Pod: "node-exporter-*"},
+					{Container: "istio-proxy"},
+				},
+			},
+		},
+	}
+
+	DescribeTable("getEnforcementAction",
+		func(namespace string, expectedAction config.EnforcementAction, expectedPolicyIndex int) {
+			action, policy := r.getEnforcementAction(namespace)
+			Expect(action).To(Equal(expectedAction))
+			Expect(policy).To(BeIdenticalTo(&r.cfg.Policy.Namespaces[expectedPolicyIndex]))
+		},
+		Entry("exact match", "kube-system", config.EnforcementActionAudit, 0),
+		Entry("glob match", "user-alice", config.EnforcementActionDrop, 1),
+		Entry("first matching policy is used", "default", config.EnforcementActionAudit, 2),
+	)
+
+	It("getEnforcementAction returns default action when no policy matches", func() {
+		r := strictSupplementalGroupsRuntime{
+			cfg: &config.Config{
+				Policy: config.PolicyConfig{
+					DefaultAction: config.EnforcementActionDeny,
+					Namespaces:    []config.NamespacePolicy{{Namespace: "kube-*", Action: config.EnforcementActionAudit}},
+				},
+			},
+		}
+		action, policy := r.getEnforcementAction("user-alice")
+		Expect(action).To(Equal(config.EnforcementActionDeny))
+		Expect(policy).To(BeNil())
+	})
+
+	DescribeTable("findExemption",
+		func(ctrInfo bundle.ContainerInfo, expectedExemptionIndex int) {
+			exemption := r.findExemption(&ctrInfo)
+			if expectedExemptionIndex < 0 {
+				Expect(exemption).To(BeNil())
+				return
+			}
+			Expect(exemption).To(BeIdenticalTo(&r.cfg.Policy.Exemptions[expectedExemptionIndex]))
+		},
+		Entry("namespace/pod match", bundle.ContainerInfo{PodNamespace: "monitoring", PodName: "node-exporter-abcde", ContainerName: "exporter"}, 0),
+		Entry("pod name unmatch", bundle.ContainerInfo{PodNamespace: "monitoring", PodName: "prometheus-0", ContainerName: "prometheus"}, -1),
+		Entry("namespace unmatch", bundle.ContainerInfo{PodNamespace: "user-alice", PodName: "node-exporter-abcde", ContainerName: "exporter"}, -1),
+		Entry("container match", bundle.ContainerInfo{PodNamespace: "user-alice", PodName: "app", ContainerName: "istio-proxy"}, 1),
+	)
+})
@@ -0,0 +1,93 @@
+package runtime
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/rs/zerolog"
+
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/oci/bundle"
+)
+
+const (
+	// defaultCheckpointImageDir is the checkpoint image directory in the working directory used by runc by default
+	defaultCheckpointImageDir = "checkpoint"
+)
+
+func (r *strictSupplementalGroupsRuntime) enforceSupplementalGroupsOnRestore(logger zerolog.Logger, crArgs *RuntimeArgs) error {
+	b, err := bundle.NewBundle(crArgs.Options.Bundle)
+	if err != nil {
+		return fmt.Errorf("Fail to load OCI bundle: %w", err)
+	}
+
+	imagePath := crArgs.Options.ImagePath
+	if imagePath == "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("Failed to resolve default checkpoint image path: %w", err)
+		}
+		imagePath = filepath.Join(cwd, defaultCheckpointImageDir)
+	}
+
+	logger = logger.With().Str("BundleDir", b.Dir).Str("ImagePath", imagePath).Logger()
+	return r.enforceSupplementalGroupsOnBundle(logger, b, crArgs, imagePath)
+}
+
+// verifyCheckpointedProcess verifies credentials of the checkpointed threads read from core-*.img in the CRIU
+// image.  The credentials are restored from the checkpoint image regardless of the OCI bundle, and they can't be
+// modified.  So, violations are rejected even when the enforcement action is "drop".  Checkpoints whose credentials
+// can't be read are rejected too except in "audit" action.
+func (r *strictSupplementalGroupsRuntime) verifyCheckpointedProcess(
+	logger zerolog.Logger,
+	imagePath string,
+	decision *enforcementDecision,
+) error {
+	action := decision.Action
+	credsList, err := readCriuCreds(imagePath)
+	if err != nil {
+		violation := fmt.Errorf("credentials of the checkpointed process can't be verified: %v", err)
+		_, err := resolveViolation(logger, action, violation, nil)
+		return err
+	}
+
+	for _, creds := range credsList {
+		logger := logger.With().Str("CoreImage", creds.File).Logger()
+		process := specs.Process{User: specs.User{UID: creds.UID, GID: creds.GID, AdditionalGids: checkpointedAdditionalGids(creds)}}
+		user := process.User
+
+		enforced, err := r.enforceSupplementalGroupsOnProcessSpec(logger, &process, decision)
+		if err != nil {
+			return fmt.Errorf("Checkpointed process violates the policy: %w", err)
+		}
+		if enforced {
+			violation := fmt.Errorf(
+				"credentials of the checkpointed process (uid=%d, gid=%d, additionalGids=%v) in %s can't be modified on restore",
+				user.UID, user.GID, user.AdditionalGids, creds.File,
+			)
+			if _, err := resolveViolation(logger, action, violation, nil); err != nil {
+				return err
+			}
+		}
+	}
+	logger.Info().Int("CoreImages", len(credsList)).Msg("Credentials of the checkpointed process verified")
+	return nil
+}
+
+// checkpointedAdditionalGids returns the groups of the checkpointed thread and its effective, saved and
+// filesystem gids different from the real gid.  The thread can switch to any of them without privileges.
+func checkpointedAdditionalGids(creds criuCreds) []uint32 {
+	gids := append([]uint32{}, creds.Groups...)
+	seen := map[uint32]struct{}{creds.GID: {}}
+	for _, gid := range creds.Groups {
+		seen[gid] = struct{}{}
+	}
+	for _, gid := range []uint32{creds.Egid, creds.Sgid, creds.Fsgid} {
+		if _, ok := seen[gid]; !ok {
+			seen[gid] = struct{}{}
+			gids = append(gids, gid)
+		}
+	}
+	return gids
+}
@@ -0,0 +1,131 @@
+package runtime
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	zlog "github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/encoding/protowire"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/pointer"
+
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/config"
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/kubelet"
+)
+
+var _ = Describe("verifyCheckpointedProcess", func() {
+	r := strictSupplementalGroupsRuntime{cfg: &config.Config{PrimaryGroupPolicy: config.PrimaryGroupPolicyIgnore}}
+	pod := &kubelet.Pod{Pod: corev1.Pod{
+		Spec: corev1.PodSpec{
+			SecurityContext: &corev1.PodSecurityContext{
+				RunAsUser:          pointer.Int64(1000),
+				RunAsGroup:         pointer.Int64(1000),
+				SupplementalGroups: []int64{2000},
+			},
+		},
+	}}
+	newDecision := func(action config.EnforcementAction) *enforcementDecision {
+		decision, err := r.newEnforcementDecision(zlog.Logger, pod, "", action)
+		Expect(err).NotTo(HaveOccurred())
+		return decision
+	}
+	appendUint32 := func(b []byte, v uint32) []byte {
+		le := make([]byte, 4)
+		binary.LittleEndian.PutUint32(le, v)
+		return append(b, le...)
+	}
+	// coreImage returns core-*.img whose thread has the credentials
+	coreImage := func(uid, gid, egid uint32, groups []uint32) []byte {
+		var creds []byte
+		for _, f := range []struct {
+			num   protowire.Number
+			value uint32
+		}{{1, uid}, {2, gid}, {3, uid}, {4, egid}, {5, uid}, {6, gid}, {7, uid}, {8, gid}, {13, 0}} {
+			creds = protowire.AppendTag(creds, f.num, protowire.VarintType)
+			creds = protowire.AppendVarint(creds, uint64(f.value))
+		}
+		for _, group := range groups {
+			creds = protowire.AppendTag(creds, 14, protowire.VarintType)
+			creds = protowire.AppendVarint(creds, uint64(group))
+		}
+		var threadCore []byte
+		threadCore = protowire.AppendTag(threadCore, 1, protowire.VarintType)
+		threadCore = protowire.AppendVarint(threadCore, 0)
+		threadCore = protowire.AppendTag(threadCore, 10, protowire.BytesType)
+		threadCore = protowire.AppendBytes(threadCore, creds)
+		var core []byte
+		core = protowire.AppendTag(core, 1, protowire.VarintType)
+		core = protowire.AppendVarint(core, 1)
+		core = protowire.AppendTag(core, 5, protowire.BytesType)
+		core = protowire.AppendBytes(core, threadCore)
+
+		image := appendUint32(nil, criuImgCommonMagic)
+		image = appendUint32(image, criuCoreMagic)
+		image = appendUint32(image, uint32(len(core)))
+		return append(image, core...)
+	}
+	writeCheckpoint := func(images ...[]byte) string {
+		imagePath := GinkgoT().TempDir()
+		for i, image := range images {
+			Expect(os.WriteFile(filepath.Join(imagePath, fmt.Sprintf("core-%d.img", i+1)), image, 0644)).To(Succeed())
+		}
+		return imagePath
+	}
+
+	It("reads credentials from core images", func() {
+		imagePath := writeCheckpoint(coreImage(1000, 1000, 2000, []uint32{2000, 3000}))
+		credsList, err := readCriuCreds(imagePath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(credsList).To(Equal([]criuCreds{{
+			File: "core-1.img", UID: 1000, GID: 1000, Egid: 2000, Sgid: 1000, Fsgid: 1000, Groups: []uint32{2000, 3000},
+		}}))
+		Expect(checkpointedAdditionalGids(credsList[0])).To(Equal([]uint32{2000, 3000}))
+	})
+
+	It("accepts checkpointed process with allowed gids", func() {
+		imagePath := writeCheckpoint(coreImage(1000, 1000, 1000, []uint32{2000}), coreImage(1000, 1000, 2000, nil))
+		Expect(r.verifyCheckpointedProcess(zlog.Logger, imagePath, newDecision(config.EnforcementActionDrop))).To(Succeed())
+	})
+
+	DescribeTable("checkpointed process with disallowed gids",
+		func(action config.EnforcementAction, succeed bool) {
+			// a thread of the process has a disallowed gid
+			imagePath := writeCheckpoint(coreImage(1000, 1000, 1000, []uint32{2000}), coreImage(1000, 1000, 3000, []uint32{2000}))
+			err := r.verifyCheckpointedProcess(zlog.Logger, imagePath, newDecision(action))
+			if succeed {
+				Expect(err).NotTo(HaveOccurred())
+			} else {
+				Expect(err).To(HaveOccurred())
+			}
+		},
+		Entry("is rejected in drop action", config.EnforcementActionDrop, false),
+		Entry("is denied in deny action", config.EnforcementActionDeny, false),
+		Entry("is allowed in audit action", config.EnforcementActionAudit, true),
+	)
+
+	DescribeTable("checkpoint whose credentials can't be read",
+		func(action config.EnforcementAction, images [][]byte, succeed bool) {
+			imagePath := writeCheckpoint(images...)
+			err := r.verifyCheckpointedProcess(zlog.Logger, imagePath, newDecision(action))
+			if succeed {
+				Expect(err).NotTo(HaveOccurred())
+			} else {
+				Expect(err).To(HaveOccurred())
+			}
+		},
+		Entry("without core images is rejected in drop action", config.EnforcementActionDrop, nil, false),
+		Entry("without core images is denied in deny action", config.EnforcementActionDeny, nil, false),
+		Entry("without core images is allowed in audit action", config.EnforcementActionAudit, nil, true),
+		Entry("with a broken core image is rejected in drop action",
+			config.EnforcementActionDrop, [][]byte{coreImage(1000, 1000, 1000, nil)[:20]}, false,
+		),
+		Entry("with a core image of another type is rejected in drop action",
+			config.EnforcementActionDrop, [][]byte{appendUint32(nil, criuImgCommonMagic)}, false,
+		),
+	)
+})
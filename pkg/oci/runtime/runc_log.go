@@ -0,0 +1,95 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// runcLogWriter converts zerolog's JSON events to runc's log lines, which are written by logrus in runc.
+// CRI implementations (containerd, cri-o) read "msg" of the last error line in the runtime's log file
+// to report why the runtime failed (e.g. in pod events).
+//
+//	json: {"level":"error","msg":"...","time":"2022-11-10T05:57:14Z"}
+//	text: time="2022-11-10T05:57:14Z" level=error msg="..."
+type runcLogWriter struct {
+	out    io.Writer
+	format string
+}
+
+func newRuncLogWriter(out io.Writer, format string) *runcLogWriter {
+	return &runcLogWriter{out: out, format: format}
+}
+
+func (w *runcLogWriter) Write(p []byte) (int, error) {
+	var event map[string]interface{}
+	if err := json.Unmarshal(p, &event); err != nil {
+		// not an event of zerolog
+		_, err := w.out.Write(p)
+		return len(p), err
+	}
+
+	level, _ := event[zerolog.LevelFieldName].(string)
+	if level == zerolog.LevelWarnValue {
+		level = "warning"
+	}
+	msg, _ := event[zerolog.MessageFieldName].(string)
+	if errMsg, ok := event[zerolog.ErrorFieldName].(string); ok {
+		// CRI implementations report only "msg"
+		if msg == "" {
+			msg = errMsg
+		} else {
+			msg = msg + ": " + errMsg
+		}
+	}
+	timestamp, _ := event[zerolog.TimestampFieldName].(string)
+	if timestamp == "" {
+		timestamp = time.Now().Format(time.RFC3339)
+	}
+	for _, k := range []string{zerolog.LevelFieldName, zerolog.MessageFieldName, zerolog.ErrorFieldName, zerolog.TimestampFieldName} {
+		delete(event, k)
+	}
+	keys := make([]string, 0, len(event))
+	for k := range event {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var line bytes.Buffer
+	if w.format == "text" {
+		fmt.Fprintf(&line, "time=%s level=%s msg=%s", strconv.Quote(timestamp), level, strconv.Quote(msg))
+		for _, k := range keys {
+			value, _ := json.Marshal(event[k])
+			if s, ok := event[k].(string); ok {
+				value = []byte(strconv.Quote(s))
+			}
+			fmt.Fprintf(&line, " %s=%s", k, value)
+		}
+	} else {
+		writeJSONField := func(prefix, k string, v interface{}) {
+			key, _ := json.Marshal(k)
+			value, _ := json.Marshal(v)
+			line.WriteString(prefix)
+			line.Write(key)
+			line.WriteByte(':')
+			line.Write(value)
+		}
+		writeJSONField("{", "level", level)
+		writeJSONField(",", "msg", msg)
+		writeJSONField(",", "time", timestamp)
+		for _, k := range keys {
+			writeJSONField(",", k, event[k])
+		}
+		line.WriteByte('}')
+	}
+	line.WriteByte('\n')
+
+	_, err := w.out.Write(line.Bytes())
+	return len(p), err
+}
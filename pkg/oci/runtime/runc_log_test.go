@@ -0,0 +1,35 @@
+package runtime
+
+import (
+	"bytes"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/rs/zerolog"
+)
+
+var _ = Describe("runcLogWriter", func() {
+	It("writes runc's json log lines", func() {
+		var out bytes.Buffer
+		logger := zerolog.New(newRuncLogWriter(&out, "json")).With().Timestamp().Str("ContainerId", "ctr").Logger()
+		logger.Error().Err(fmt.Errorf("Denied the container: additionalGids [50000] are not in (supplementalGroups ∪ fsGroup)")).Msg("Failed to enforce SupplementalGroups")
+		logger.Warn().Msg("Detected violation")
+
+		lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+		Expect(lines).To(HaveLen(2))
+		Expect(string(lines[0])).To(MatchRegexp(
+			`^\{"level":"error","msg":"Failed to enforce SupplementalGroups: Denied the container: additionalGids \[50000\] are not in \(supplementalGroups ∪ fsGroup\)","time":"[^"]+","ContainerId":"ctr"\}$`,
+		))
+		Expect(string(lines[1])).To(MatchRegexp(`^\{"level":"warning","msg":"Detected violation","time":"[^"]+","ContainerId":"ctr"\}$`))
+	})
+
+	It("writes runc's text log lines", func() {
+		var out bytes.Buffer
+		logger := zerolog.New(newRuncLogWriter(&out, "text")).With().Timestamp().Logger()
+		logger.Info().Int("Gid", 1000).Msg("Container info loaded")
+
+		Expect(out.String()).To(MatchRegexp(`^time="[^"]+" level=info msg="Container info loaded" Gid=1000\n$`))
+	})
+})
@@ -0,0 +1,316 @@
+package runtime
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"syscall"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const (
+	// seccompGidNoChange is (gid_t)-1 which keeps the gid unchanged in setregid(2) and setresgid(2)
+	seccompGidNoChange = uint32(0xFFFFFFFF)
+
+	// seccompGidMask masks the upper 32 bits of syscall arguments because gid_t is 32-bit.
+	// The kernel ignores them, so comparing them would let a process evade rules by setting garbage there.
+	seccompGidMask = uint64(0xFFFFFFFF)
+
+	// maxSeccompArgCombinations is the maximum number of ALLOW rules generated for a syscall.
+	// Merging fails when more rules are needed to allow gids.
+	maxSeccompArgCombinations = 256
+)
+
+type seccompGidSyscalls struct {
+	names []string
+	// gidArgs is the number of gid arguments.  0 means the arguments can not be inspected (i.e. pointers).
+	gidArgs int
+}
+
+var seccompGidSyscallsList = []seccompGidSyscalls{
+	{names: []string{"setgroups", "setgroups32"}, gidArgs: 0},
+	{names: []string{"setgid", "setgid32"}, gidArgs: 1},
+	{names: []string{"setregid", "setregid32"}, gidArgs: 2},
+	{names: []string{"setresgid", "setresgid32"}, gidArgs: 3},
+}
+
+// seccompCube is the set of gids matching (gid & mask) == value
+type seccompCube struct {
+	mask  uint64
+	value uint64
+}
+
+// mergeSeccompGidRules merges rules restricting syscalls which change gids to allowedGids into the seccomp profile.
+// The profile is only tightened: rules for the other syscalls and non-ALLOW rules for the target syscalls are kept intact.
+// An unconfined (nil) profile is replaced with a profile allowing the other syscalls.
+//
+// When the default action is permissive (SCMP_ACT_ALLOW or SCMP_ACT_LOG), ALLOW(and LOG) rules for the target syscalls
+// are removed and ERRNO(EPERM) rules are added for setgroups(2) family (seccomp can not inspect the gid list) and
+// for setgid(2), setregid(2) and setresgid(2) families with any gid argument not in allowedGids (and -1 for
+// setregid/setresgid).
+//
+// Otherwise, ALLOW rules for the target syscalls are narrowed to allowedGids and other calls result in the default action.
+// setgroups(2) family is left to the default action.  A syscall not allowed by the profile stays not allowed.
+// Merging fails when narrowing needs more than maxSeccompArgCombinations rules.
+//
+// Arguments are compared with SCMP_CMP_MASKED_EQ on the lower 32 bits because gid_t is 32-bit.
+func mergeSeccompGidRules(seccomp *specs.LinuxSeccomp, allowedGids []uint32) (*specs.LinuxSeccomp, error) {
+	if seccomp == nil {
+		seccomp = &specs.LinuxSeccomp{DefaultAction: specs.ActAllow}
+	}
+	permissiveDefault := isSeccompAllowAction(seccomp.DefaultAction)
+
+	families := map[string]seccompGidSyscalls{}
+	for _, s := range seccompGidSyscallsList {
+		for _, name := range s.names {
+			families[name] = s
+		}
+	}
+
+	// keep rules for the other syscalls and non-ALLOW rules for the target syscalls.
+	// ALLOW rules for the target syscalls are replaced with narrowed ones below.
+	syscalls := []specs.LinuxSyscall{}
+	allowRules := map[string][]specs.LinuxSyscall{}
+	keptRules := map[string][]specs.LinuxSyscall{}
+	for _, rule := range seccomp.Syscalls {
+		names := []string{}
+		for _, name := range rule.Names {
+			if _, ok := families[name]; !ok {
+				names = append(names, name)
+				continue
+			}
+			if isSeccompAllowAction(rule.Action) {
+				allowRules[name] = append(allowRules[name], rule)
+				continue
+			}
+			keptRules[name] = append(keptRules[name], rule)
+			names = append(names, name)
+		}
+		if len(names) == 0 {
+			continue
+		}
+		rule.Names = names
+		syscalls = append(syscalls, rule)
+	}
+
+	values := []uint32{}
+	values = append(values, allowedGids...)
+	for _, s := range seccompGidSyscallsList {
+		familyValues := values
+		if s.gidArgs > 1 {
+			familyValues = append(append([]uint32{}, values...), seccompGidNoChange)
+		}
+
+		// names having the same rules are merged into a rule
+		var generated [][]specs.LinuxSyscall
+		var generatedNames [][]string
+		for _, name := range s.names {
+			var rules []specs.LinuxSyscall
+			var err error
+			if permissiveDefault {
+				rules = seccompDenyRules(s.gidArgs, familyValues, keptRules[name])
+			} else {
+				rules, err = seccompAllowRules(s.gidArgs, familyValues, allowRules[name], keptRules[name])
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", name, err)
+				}
+			}
+			merged := false
+			for i := range generated {
+				if reflect.DeepEqual(generated[i], rules) {
+					generatedNames[i] = append(generatedNames[i], name)
+					merged = true
+					break
+				}
+			}
+			if !merged {
+				generated = append(generated, rules)
+				generatedNames = append(generatedNames, []string{name})
+			}
+		}
+		for i, rules := range generated {
+			for _, rule := range rules {
+				rule.Names = generatedNames[i]
+				syscalls = append(syscalls, rule)
+			}
+		}
+	}
+
+	merged := *seccomp
+	merged.Syscalls = syscalls
+	return &merged, nil
+}
+
+// seccompDenyRules returns ERRNO(EPERM) rules denying the syscall with any gid argument not in values.
+// No rule is returned when a kept rule already denies the syscall unconditionally.
+func seccompDenyRules(gidArgs int, values []uint32, keptRules []specs.LinuxSyscall) []specs.LinuxSyscall {
+	for _, rule := range keptRules {
+		if len(rule.Args) == 0 {
+			return nil
+		}
+	}
+	eperm := uint(syscall.EPERM)
+	if gidArgs == 0 {
+		return []specs.LinuxSyscall{{Action: specs.ActErrno, ErrnoRet: &eperm}}
+	}
+	// the rules are disjoint from gids in values because cubes cover exactly the complement of values
+	rules := []specs.LinuxSyscall{}
+	for i := 0; i < gidArgs; i++ {
+		for _, cube := range seccompCubes(values, true) {
+			rules = append(rules, specs.LinuxSyscall{
+				Action:   specs.ActErrno,
+				ErrnoRet: &eperm,
+				Args:     []specs.LinuxSeccompArg{cube.arg(uint(i))},
+			})
+		}
+	}
+	return rules
+}
+
+// seccompAllowRules returns ALLOW rules narrowing allowRules to the syscall with all gid arguments in values.
+// Calls matching keptRules are left to them.
+func seccompAllowRules(gidArgs int, values []uint32, allowRules, keptRules []specs.LinuxSyscall) ([]specs.LinuxSyscall, error) {
+	if gidArgs == 0 || len(allowRules) == 0 {
+		return nil, nil
+	}
+
+	conditional := len(keptRules) > 0
+	for _, rule := range allowRules {
+		if len(rule.Args) > 0 {
+			conditional = true
+		}
+	}
+
+	// cubes covering exactly values keep the number of rules small for ranges of gids (e.g. 60000-60007).
+	// Exact gids are needed to evaluate conditions of the rules in the profile.
+	var cubes []seccompCube
+	if conditional {
+		for _, v := range values {
+			cubes = append(cubes, seccompCube{mask: seccompGidMask, value: uint64(v)})
+		}
+	} else {
+		cubes = seccompCubes(values, false)
+	}
+	total := 1
+	for i := 0; i < gidArgs; i++ {
+		total *= len(cubes)
+		if total > maxSeccompArgCombinations {
+			return nil, fmt.Errorf("allowing %d gids needs more than %d seccomp rules", len(values), maxSeccompArgCombinations)
+		}
+	}
+
+	rules := []specs.LinuxSyscall{}
+	for _, combination := range seccompArgCombinations(cubes, gidArgs) {
+		if conditional {
+			gids := []uint64{}
+			for _, cube := range combination {
+				gids = append(gids, cube.value)
+			}
+			if !matchesAnySeccompRule(allowRules, gids, false) || matchesAnySeccompRule(keptRules, gids, true) {
+				continue
+			}
+		}
+		args := []specs.LinuxSeccompArg{}
+		for i, cube := range combination {
+			args = append(args, cube.arg(uint(i)))
+		}
+		rules = append(rules, specs.LinuxSyscall{Action: specs.ActAllow, Args: args})
+	}
+	return rules, nil
+}
+
+// matchesAnySeccompRule reports whether the syscall with gids as arguments matches any of rules.
+// Conditions on the other arguments are assumed to match when unknownMatches, otherwise not to match.
+func matchesAnySeccompRule(rules []specs.LinuxSyscall, gids []uint64, unknownMatches bool) bool {
+	for _, rule := range rules {
+		matched := true
+		for _, arg := range rule.Args {
+			if int(arg.Index) >= len(gids) {
+				matched = matched && unknownMatches
+				continue
+			}
+			matched = matched && matchesSeccompArg(arg, gids[arg.Index])
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesSeccompArg(arg specs.LinuxSeccompArg, value uint64) bool {
+	switch arg.Op {
+	case specs.OpNotEqual:
+		return value != arg.Value
+	case specs.OpLessThan:
+		return value < arg.Value
+	case specs.OpLessEqual:
+		return value <= arg.Value
+	case specs.OpEqualTo:
+		return value == arg.Value
+	case specs.OpGreaterEqual:
+		return value >= arg.Value
+	case specs.OpGreaterThan:
+		return value > arg.Value
+	case specs.OpMaskedEqual:
+		return value&arg.Value == arg.ValueTwo
+	}
+	return false
+}
+
+func isSeccompAllowAction(action specs.LinuxSeccompAction) bool {
+	return action == specs.ActAllow || action == specs.ActLog
+}
+
+func (c seccompCube) arg(index uint) specs.LinuxSeccompArg {
+	return specs.LinuxSeccompArg{Index: index, Value: c.mask, ValueTwo: c.value, Op: specs.OpMaskedEqual}
+}
+
+// seccompCubes returns disjoint cubes covering exactly values (or the complement of values) in 32-bit gids
+func seccompCubes(values []uint32, complement bool) []seccompCube {
+	set := map[uint32]struct{}{}
+	for _, v := range values {
+		set[v] = struct{}{}
+	}
+	sorted := []uint32{}
+	for v := range set {
+		sorted = append(sorted, v)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	cubes := []seccompCube{}
+	var cover func(prefixLen uint, prefix uint32, values []uint32)
+	cover = func(prefixLen uint, prefix uint32, values []uint32) {
+		full := prefixLen > 0 && uint64(len(values)) == uint64(1)<<(32-prefixLen)
+		if (!complement && full) || (complement && len(values) == 0) {
+			mask := seccompGidMask &^ (seccompGidMask >> prefixLen)
+			cubes = append(cubes, seccompCube{mask: mask, value: uint64(prefix)})
+			return
+		}
+		if (!complement && len(values) == 0) || (complement && full) || prefixLen == 32 {
+			return
+		}
+		bit := uint32(1) << (31 - prefixLen)
+		split := sort.Search(len(values), func(i int) bool { return values[i]&bit != 0 })
+		cover(prefixLen+1, prefix, values[:split])
+		cover(prefixLen+1, prefix|bit, values[split:])
+	}
+	cover(0, 0, sorted)
+	return cubes
+}
+
+// seccompArgCombinations returns all the combinations of cubes for n arguments
+func seccompArgCombinations(cubes []seccompCube, n int) [][]seccompCube {
+	combinations := [][]seccompCube{{}}
+	for i := 0; i < n; i++ {
+		next := [][]seccompCube{}
+		for _, c := range combinations {
+			for _, cube := range cubes {
+				next = append(next, append(append([]seccompCube{}, c...), cube))
+			}
+		}
+		combinations = next
+	}
+	return combinations
+}
@@ -0,0 +1,247 @@
+package runtime
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	zlog "github.com/rs/zerolog/log"
+
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/config"
+)
+
+var _ = Describe("Seccomp", func() {
+	r := strictSupplementalGroupsRuntime{cfg: &config.Config{
+		Hardening: config.HardeningConfig{Seccomp: true},
+	}}
+	eperm := uint(syscall.EPERM)
+	loadSpec := func(name string) *specs.Spec {
+		specRaw, err := os.ReadFile(filepath.Join("testdata", "seccomp", name))
+		Expect(err).NotTo(HaveOccurred())
+		var spec specs.Spec
+		Expect(json.Unmarshal(specRaw, &spec)).To(Succeed())
+		return &spec
+	}
+	masked := func(index uint, value uint64) specs.LinuxSeccompArg {
+		return specs.LinuxSeccompArg{Index: index, Value: seccompGidMask, ValueTwo: value, Op: specs.OpMaskedEqual}
+	}
+	// actions returns actions of rules matching the syscall with args
+	actions := func(seccomp *specs.LinuxSeccomp, name string, args ...uint64) []specs.LinuxSeccompAction {
+		matched := []specs.LinuxSeccompAction{}
+		for _, rule := range seccomp.Syscalls {
+			for _, n := range rule.Names {
+				if n != name {
+					continue
+				}
+				if len(rule.Args) == 0 || matchesAnySeccompRule([]specs.LinuxSyscall{rule}, args, false) {
+					matched = append(matched, rule.Action)
+				}
+			}
+		}
+		return matched
+	}
+
+	It("keeps RuntimeDefault profile and narrows allowed syscalls to gids of the process", func() {
+		spec := loadSpec("runtime-default.json")
+		Expect(r.hardenSpec(zlog.Logger, spec, config.EnforcementActionDrop)).To(BeTrue())
+
+		seccomp := spec.Linux.Seccomp
+		Expect(seccomp.DefaultAction).To(Equal(specs.ActErrno))
+		Expect(seccomp.Architectures).To(Equal(loadSpec("runtime-default.json").Linux.Seccomp.Architectures))
+
+		// existing rules are kept except ALLOW rules for target syscalls
+		Expect(seccomp.Syscalls[0]).To(Equal(specs.LinuxSyscall{
+			Names:  []string{"accept", "read", "setuid", "write"},
+			Action: specs.ActAllow,
+		}))
+		Expect(seccomp.Syscalls[1].Names).To(Equal([]string{"personality"}))
+
+		// setgroups is left to the default action
+		rules := seccomp.Syscalls[2:]
+		Expect(rules[0:2]).To(Equal([]specs.LinuxSyscall{
+			{Names: []string{"setgid", "setgid32"}, Action: specs.ActAllow, Args: []specs.LinuxSeccompArg{masked(0, 1000)}},
+			{Names: []string{"setgid", "setgid32"}, Action: specs.ActAllow, Args: []specs.LinuxSeccompArg{masked(0, 60000)}},
+		}))
+		// (1000, 60000, -1) for 2 args and 3 args.  setregid32 is not allowed by the profile
+		Expect(rules[2:]).To(HaveLen(3*3 + 3*3*3))
+		Expect(rules[2]).To(Equal(specs.LinuxSyscall{
+			Names:  []string{"setregid"},
+			Action: specs.ActAllow,
+			Args:   []specs.LinuxSeccompArg{masked(0, 1000), masked(1, 1000)},
+		}))
+		Expect(rules[len(rules)-1]).To(Equal(specs.LinuxSyscall{
+			Names:  []string{"setresgid", "setresgid32"},
+			Action: specs.ActAllow,
+			Args: []specs.LinuxSeccompArg{
+				masked(0, uint64(seccompGidNoChange)), masked(1, uint64(seccompGidNoChange)), masked(2, uint64(seccompGidNoChange)),
+			},
+		}))
+		Expect(actions(seccomp, "setgroups")).To(BeEmpty())
+		Expect(actions(seccomp, "setregid32", 1000, 1000)).To(BeEmpty())
+		Expect(actions(seccomp, "setgid", 0)).To(BeEmpty())
+		// upper 32 bits are ignored by the kernel
+		Expect(actions(seccomp, "setgid", 0x100000000|1000)).To(Equal([]specs.LinuxSeccompAction{specs.ActAllow}))
+	})
+
+	It("creates a profile for Unconfined which allows only gids of the process", func() {
+		spec := loadSpec("unconfined.json")
+		Expect(r.hardenSpec(zlog.Logger, spec, config.EnforcementActionDrop)).To(BeTrue())
+
+		seccomp := spec.Linux.Seccomp
+		Expect(seccomp.DefaultAction).To(Equal(specs.ActAllow))
+		Expect(seccomp.Syscalls[0]).To(Equal(specs.LinuxSyscall{
+			Names: []string{"setgroups", "setgroups32"}, Action: specs.ActErrno, ErrnoRet: &eperm,
+		}))
+		for _, rule := range seccomp.Syscalls[1:] {
+			Expect(rule.Action).To(Equal(specs.ActErrno))
+			Expect(rule.Args).To(HaveLen(1))
+			Expect(rule.Args[0].Op).To(Equal(specs.OpMaskedEqual))
+		}
+
+		denied := []specs.LinuxSeccompAction{specs.ActErrno}
+		for _, name := range []string{"setgid", "setgid32"} {
+			Expect(actions(seccomp, name, 1000)).To(BeEmpty())
+			Expect(actions(seccomp, name, 60000)).To(BeEmpty())
+			Expect(actions(seccomp, name, 0)).To(Equal(denied))
+			Expect(actions(seccomp, name, 1001)).To(Equal(denied))
+			Expect(actions(seccomp, name, uint64(seccompGidNoChange))).To(Equal(denied))
+		}
+		Expect(actions(seccomp, "setregid", 1000, uint64(seccompGidNoChange))).To(BeEmpty())
+		Expect(actions(seccomp, "setregid", 60000, 0)).To(Equal(denied))
+		Expect(actions(seccomp, "setresgid32", 1000, 60000, uint64(seccompGidNoChange))).To(BeEmpty())
+		Expect(actions(seccomp, "setresgid32", 0, 0, 0)).To(Equal([]specs.LinuxSeccompAction{specs.ActErrno, specs.ActErrno, specs.ActErrno}))
+	})
+
+	It("keeps Localhost profile and adds rules for target syscalls", func() {
+		spec := loadSpec("localhost.json")
+		Expect(r.hardenSpec(zlog.Logger, spec, config.EnforcementActionDrop)).To(BeTrue())
+
+		enosys := uint(38)
+		seccomp := spec.Linux.Seccomp
+		Expect(seccomp.DefaultAction).To(Equal(specs.ActAllow))
+		Expect(seccomp.Syscalls[0]).To(Equal(specs.LinuxSyscall{
+			Names:    []string{"mount", "setgroups"},
+			Action:   specs.ActErrno,
+			ErrnoRet: &enosys,
+		}))
+		// setgroups is already denied
+		Expect(seccomp.Syscalls[1]).To(Equal(specs.LinuxSyscall{
+			Names:    []string{"setgroups32"},
+			Action:   specs.ActErrno,
+			ErrnoRet: &eperm,
+		}))
+		Expect(actions(seccomp, "setgroups")).To(Equal([]specs.LinuxSeccompAction{specs.ActErrno}))
+		Expect(actions(seccomp, "setgid", 1000)).To(BeEmpty())
+		Expect(actions(seccomp, "setgid", 0)).To(Equal([]specs.LinuxSeccompAction{specs.ActErrno}))
+	})
+
+	It("never loosens Localhost profile denying setgid", func() {
+		spec := loadSpec("localhost-deny-setgid.json")
+		Expect(r.hardenSpec(zlog.Logger, spec, config.EnforcementActionDrop)).To(BeTrue())
+
+		one := uint(1)
+		seccomp := spec.Linux.Seccomp
+		original := loadSpec("localhost-deny-setgid.json").Linux.Seccomp.Syscalls
+		Expect(seccomp.Syscalls[0:2]).To(Equal(original))
+		for _, rule := range seccomp.Syscalls[2:] {
+			Expect(rule.Names).NotTo(ContainElement("setgid"))
+			Expect(rule.Names).NotTo(ContainElement("setgid32"))
+			Expect(rule.Names).NotTo(ContainElement("setregid"))
+			Expect(rule.Action).To(Equal(specs.ActErrno))
+			Expect(rule.ErrnoRet).To(Equal(&eperm))
+		}
+
+		// denied by the profile even for allowed gids
+		Expect(actions(seccomp, "setgid", 1000)).To(Equal([]specs.LinuxSeccompAction{specs.ActErrno}))
+		Expect(seccomp.Syscalls[0].ErrnoRet).To(Equal(&one))
+		Expect(actions(seccomp, "setregid", 1000, 1000)).To(Equal([]specs.LinuxSeccompAction{specs.ActErrno}))
+		// conditionally denied by the profile and additionally restricted to allowed gids
+		Expect(actions(seccomp, "setresgid", 0, 1000, 1000)).To(ContainElement(specs.ActErrno))
+		Expect(actions(seccomp, "setresgid", 1000, 1000, 1000)).To(BeEmpty())
+		Expect(actions(seccomp, "setresgid", 1000, 0, 1000)).To(Equal([]specs.LinuxSeccompAction{specs.ActErrno}))
+		Expect(actions(seccomp, "setregid32", 1000, 60000)).To(BeEmpty())
+		Expect(actions(seccomp, "setregid32", 1000, 1)).To(Equal([]specs.LinuxSeccompAction{specs.ActErrno}))
+	})
+
+	It("narrows conditional rules in the profile denying setgid by default", func() {
+		eperm := uint(syscall.EPERM)
+		seccomp, err := mergeSeccompGidRules(&specs.LinuxSeccomp{
+			DefaultAction: specs.ActErrno,
+			Syscalls: []specs.LinuxSyscall{
+				{Names: []string{"setgid"}, Action: specs.ActAllow},
+				{
+					Names: []string{"setgid"}, Action: specs.ActErrno, ErrnoRet: &eperm,
+					Args: []specs.LinuxSeccompArg{{Index: 0, Value: 60000, Op: specs.OpEqualTo}},
+				},
+				{
+					Names: []string{"setregid"}, Action: specs.ActAllow,
+					Args: []specs.LinuxSeccompArg{{Index: 1, Value: 1000, Op: specs.OpEqualTo}},
+				},
+			},
+		}, []uint32{1000, 60000})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(seccomp.Syscalls).To(Equal([]specs.LinuxSyscall{
+			{
+				Names: []string{"setgid"}, Action: specs.ActErrno, ErrnoRet: &eperm,
+				Args: []specs.LinuxSeccompArg{{Index: 0, Value: 60000, Op: specs.OpEqualTo}},
+			},
+			{Names: []string{"setgid"}, Action: specs.ActAllow, Args: []specs.LinuxSeccompArg{masked(0, 1000)}},
+			{Names: []string{"setregid"}, Action: specs.ActAllow, Args: []specs.LinuxSeccompArg{masked(0, 1000), masked(1, 1000)}},
+			{Names: []string{"setregid"}, Action: specs.ActAllow, Args: []specs.LinuxSeccompArg{masked(0, 60000), masked(1, 1000)}},
+			{
+				Names:  []string{"setregid"},
+				Action: specs.ActAllow,
+				Args:   []specs.LinuxSeccompArg{masked(0, uint64(seccompGidNoChange)), masked(1, 1000)},
+			},
+		}))
+	})
+
+	It("sets noNewPrivileges so that runc can set up the user before the filter is installed", func() {
+		spec := loadSpec("unconfined.json")
+		spec.Process.NoNewPrivileges = false
+		Expect(r.hardenSpec(zlog.Logger, spec, config.EnforcementActionDrop)).To(BeTrue())
+		Expect(spec.Process.NoNewPrivileges).To(BeTrue())
+		Expect(actions(spec.Linux.Seccomp, "setgroups")).To(Equal([]specs.LinuxSeccompAction{specs.ActErrno}))
+	})
+
+	It("changes nothing in audit action", func() {
+		spec := loadSpec("runtime-default.json")
+		Expect(r.hardenSpec(zlog.Logger, spec, config.EnforcementActionAudit)).To(BeFalse())
+		Expect(spec).To(Equal(loadSpec("runtime-default.json")))
+	})
+
+	It("allows many gids in a profile allowing syscalls by default", func() {
+		gids := []uint32{}
+		for gid := uint32(60000); gid < 60100; gid++ {
+			gids = append(gids, gid)
+		}
+		seccomp, err := mergeSeccompGidRules(nil, gids)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(actions(seccomp, "setresgid", 60000, 60099, uint64(seccompGidNoChange))).To(BeEmpty())
+		Expect(actions(seccomp, "setresgid", 60000, 60100, 60001)).To(Equal([]specs.LinuxSeccompAction{specs.ActErrno}))
+	})
+
+	It("fails when too many rules are needed to narrow allowed syscalls", func() {
+		gids := []uint32{}
+		for gid := uint32(60000); gid < 60014; gid += 2 {
+			gids = append(gids, gid)
+		}
+		profile := &specs.LinuxSeccomp{
+			DefaultAction: specs.ActErrno,
+			Syscalls:      []specs.LinuxSyscall{{Names: []string{"setresgid"}, Action: specs.ActAllow}},
+		}
+		// (7 gids and -1)^3 > 256
+		_, err := mergeSeccompGidRules(profile, gids)
+		Expect(err).To(HaveOccurred())
+
+		spec := &specs.Spec{Process: &specs.Process{User: specs.User{AdditionalGids: gids}}, Linux: &specs.Linux{Seccomp: profile}}
+		_, err = r.hardenSpec(zlog.Logger, spec, config.EnforcementActionDrop)
+		Expect(err).To(HaveOccurred())
+	})
+})
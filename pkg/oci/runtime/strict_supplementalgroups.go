@@ -9,8 +9,8 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
-	"time"
 
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/rs/zerolog"
@@ -19,6 +19,8 @@ import (
 	corev1 "k8s.io/api/core/v1"
 
 	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/config"
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/entitlement"
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/groupdb"
 	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/kubelet"
 	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/lookup"
 	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/oci/bundle"
@@ -27,33 +29,33 @@ import (
 type GidSet map[int64]struct{}
 
 type strictSupplementalGroupsRuntime struct {
-	cfg           *config.Config
+	cfg     *config.Config
+	version string
+
+	// kubeletClient and entitlements are initialized lazily only when they are needed
 	kubeletClient *kubelet.Client
+	entitlements  *entitlement.Entitlements
 
 	runtimeLogWriter io.Writer
 	runtimeLogCtx    context.Context
 
-	underlyingRuntime Interface
+	underlyingRuntime childRuntime
 }
 
 func NewStrictSupplementalGroups(
 	cfg *config.Config,
+	version string,
 	runtimeLogWriter io.Writer,
 	runtimeLogCtx context.Context,
 ) (Interface, error) {
-	kubeletClient, err := kubelet.NewKubeletClient(cfg)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to create kubelet client: %v", err)
-	}
-
-	underlyingRuntime, err := NewExecutablePathRuntime(cfg.Runtime)
+	underlyingRuntime, err := newExecutablePathRuntime(cfg.Runtime)
 	if err != nil {
 		return nil, err
 	}
 
 	return &strictSupplementalGroupsRuntime{
-		cfg:           cfg,
-		kubeletClient: kubeletClient,
+		cfg:     cfg,
+		version: version,
 
 		runtimeLogWriter: runtimeLogWriter,
 		runtimeLogCtx:    runtimeLogCtx,
@@ -62,6 +64,31 @@ func NewStrictSupplementalGroups(
 	}, nil
 }
 
+// prepareEnforcement initializes what is needed to enforce on containers except kubelet client
+func (r *strictSupplementalGroupsRuntime) prepareEnforcement() error {
+	if r.cfg.EntitlementFile != "" && r.entitlements == nil {
+		entitlements, err := entitlement.Load(r.cfg.EntitlementFile)
+		if err != nil {
+			return err
+		}
+		r.entitlements = entitlements
+	}
+	return nil
+}
+
+// getKubeletClient returns kubelet client.  It is created on first use because
+// start and exec usually don't need it thanks to the enforcement decision.
+func (r *strictSupplementalGroupsRuntime) getKubeletClient() (*kubelet.Client, error) {
+	if r.kubeletClient == nil {
+		kubeletClient, err := kubelet.NewKubeletClient(r.cfg)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create kubelet client: %v", err)
+		}
+		r.kubeletClient = kubeletClient
+	}
+	return r.kubeletClient, nil
+}
+
 func (r *strictSupplementalGroupsRuntime) Exec(args []string) error {
 	zlog.Debug().Strs("Command", args).Msg("Container runtime command invoked")
 
@@ -69,14 +96,22 @@ func (r *strictSupplementalGroupsRuntime) Exec(args []string) error {
 	var crArgs *RuntimeArgs
 	{
 		var err error
-		crArgs, err = GetRuntimeArgs(args)
+		crArgs, err = GetRuntimeArgsWithFlags(args, r.cfg.ResolvedRuntimeProfile.Flags)
 		if err != nil {
 			return fmt.Errorf("Fail to get oci runtime args: %w", err)
 		}
 		zlog.Debug().Interface("Args", crArgs).Msg("Container runtime command line arguments parsed")
 	}
 
-	clogWriter, closeContainerLogFile, err := r.createContainerLogWriter(crArgs)
+	// commands printing information of both this runtime and the underlying runtime
+	switch {
+	case crArgs.Command == CommandFeatures:
+		return r.printFeatures(args, os.Stdout)
+	case crArgs.Command == "" && crArgs.Options.Version:
+		return r.printVersion(os.Stdout)
+	}
+
+	clogWriter, closeContainerLogFile, err := createContainerLogWriter(crArgs)
 	if err != nil {
 		return fmt.Errorf("Failed to create container Logger: %w", err)
 	}
@@ -91,35 +126,61 @@ func (r *strictSupplementalGroupsRuntime) Exec(args []string) error {
 		io.MultiWriter(r.runtimeLogWriter, clogWriter),
 	).With().Str("ContainerId", crArgs.ContainerId).Str("Command", string(crArgs.Command)).Logger()
 
-	// validate OCI spec only when "create", "start", "exec" bundle command
+	// validate OCI spec only when "create", "run", "restore", "start", "exec" bundle command
 	if err := func() error {
+		if isEnforcedCommand(crArgs.Command) {
+			if err := r.prepareEnforcement(); err != nil {
+				return err
+			}
+		}
 		switch crArgs.Command {
-		case CommandCreate:
+		case CommandCreate, CommandRun:
 			return r.enforceSupplementalGroupsOnCreate(logger, crArgs)
+		case CommandRestore:
+			return r.enforceSupplementalGroupsOnRestore(logger, crArgs)
 		case CommandStart:
 			return r.enforceSupplementalGroupsOnStart(logger, crArgs)
 		case CommandExec:
-			return r.enforceSupplementalGroupsOnExecute(logger, crArgs)
+			var err error
+			args, err = r.enforceSupplementalGroupsOnExecute(logger, crArgs, args)
+			return err
+		case CommandDelete:
+			// the decision is removed after the runtime deletes the container
+			return nil
 		default:
 			// NOP
 			logger.Info().Strs("Command", args).Msg("Ignored the invocation")
 			return nil
 		}
 	}(); err != nil {
-		zlog.Error().Err(err).Msg("Failed to enforce SupplementalGroups")
+		logger.Error().Err(err).Msg("Failed to enforce SupplementalGroups")
 		return err
 	}
 
+	if crArgs.Command == CommandDelete && r.cfg.EnforcementDecisionDir != "" {
+		return r.deleteContainer(logger, crArgs, args)
+	}
 	return r.underlyingRuntime.Exec(args)
 }
 
+// deleteContainer runs the runtime's delete command as a child process, and removes the enforcement decision only
+// after it succeeds so that start and exec can still reuse the decision of the container which failed to be deleted.
+func (r *strictSupplementalGroupsRuntime) deleteContainer(logger zerolog.Logger, crArgs *RuntimeArgs, args []string) error {
+	if err := r.underlyingRuntime.Run(args); err != nil {
+		logger.Warn().Err(err).Msg("Failed to delete the container. Kept the enforcement decision")
+		return err
+	}
+	r.deleteEnforcementDecision(logger, crArgs)
+	return nil
+}
+
 func (r *strictSupplementalGroupsRuntime) enforceSupplementalGroupsOnCreate(logger zerolog.Logger, crArgs *RuntimeArgs) error {
 	b, err := bundle.NewBundle(crArgs.Options.Bundle)
 	if err != nil {
 		return fmt.Errorf("Fail to load OCI bundle: %w", err)
 	}
 	logger = logger.With().Str("BundleDir", b.Dir).Logger()
-	return r.enforceSupplementalGroupsOnBundle(logger, b)
+	return r.enforceSupplementalGroupsOnBundle(logger, b, crArgs, "")
 }
 
 func (r *strictSupplementalGroupsRuntime) enforceSupplementalGroupsOnStart(logger zerolog.Logger, crArgs *RuntimeArgs) error {
@@ -129,21 +190,22 @@ func (r *strictSupplementalGroupsRuntime) enforceSupplementalGroupsOnStart(logge
 		return fmt.Errorf("Failed to find bundle for containerId %s: %v", crArgs.ContainerId, err)
 	}
 	logger = logger.With().Str("BundleDir", b.Dir).Logger()
-	return r.enforceSupplementalGroupsOnBundle(logger, b)
+	return r.enforceSupplementalGroupsOnBundle(logger, b, crArgs, "")
 }
 
-func (r *strictSupplementalGroupsRuntime) enforceSupplementalGroupsOnExecute(logger zerolog.Logger, crArgs *RuntimeArgs) error {
+// enforceSupplementalGroupsOnExecute enforces on the process of "exec" command and returns command line arguments to execute
+func (r *strictSupplementalGroupsRuntime) enforceSupplementalGroupsOnExecute(logger zerolog.Logger, crArgs *RuntimeArgs, args []string) ([]string, error) {
 	// find bundle from coantainerId
 	b, err := r.getBundleForContainer(crArgs.Options.Root, crArgs.ContainerId)
 	if err != nil {
-		return fmt.Errorf("Failed to find bundle for containerId %s: %v", crArgs.ContainerId, err)
+		return nil, fmt.Errorf("Failed to find bundle for containerId %s: %v", crArgs.ContainerId, err)
 	}
 	logger = logger.With().Str("BundleDir", b.Dir).Logger()
 
 	// resolve pod's namespace/name and container and its container type(sandbox, container)
 	ctrInfo, err := b.GetContainerInfo(logger, r.cfg)
 	if err != nil {
-		return fmt.Errorf("Failed to resolve container info from OCI bundle: %w", err)
+		return nil, fmt.Errorf("Failed to resolve container info from OCI bundle: %w", err)
 	}
 	logger = logger.With().
 		Str("ContainerType", ctrInfo.ContainerType).
@@ -155,69 +217,154 @@ func (r *strictSupplementalGroupsRuntime) enforceSupplementalGroupsOnExecute(log
 	// no need to enforce supplementalGroups because sandbox is not a user container.
 	if ctrInfo.ContainerType == "sandbox" {
 		logger.Info().Msg("Skip to enforce supplementalGroups for sandbox containers")
-		return nil
+		return args, nil
+	}
+
+	if exemption := r.findExemption(ctrInfo); exemption != nil {
+		logger.Info().Interface("Exemption", exemption).Msg("Skip to enforce supplementalGroups for exempted containers")
+		return args, nil
+	}
+
+	// the process is given by command line options (e.g. "exec --user 1000:1000 --additional-gids 2000 <container-id> <command>")
+	if crArgs.Options.Process == "" {
+		return r.enforceSupplementalGroupsOnExecArgs(logger, b, crArgs, args, ctrInfo)
 	}
 
 	// read process spec
 	processRaw, err := os.ReadFile(crArgs.Options.Process)
 	if err != nil {
-		return fmt.Errorf("Failed to read process file %s: %v", crArgs.Options.Process, err)
+		return nil, fmt.Errorf("Failed to read process file %s: %v", crArgs.Options.Process, err)
 	}
 	var process specs.Process
 	err = json.Unmarshal(processRaw, &process)
 	if err != nil {
-		return fmt.Errorf("Failed to unmarshal process file %s: %v", crArgs.Options.Process, err)
+		return nil, fmt.Errorf("Failed to unmarshal process file %s: %v", crArgs.Options.Process, err)
 	}
 	logger.Debug().Interface("Process", process).Msg("Process spec is parsed")
 
-	pod, err := r.kubeletClient.Pod(ctrInfo.PodNamespace, ctrInfo.PodName)
+	decision, err := r.getEnforcementDecision(logger, crArgs, ctrInfo)
 	if err != nil {
-		return fmt.Errorf("Failed to get pod: %v", err)
+		return nil, err
 	}
 
-	enforced := r.enforceSupplementalGroupsOnProcessSpec(logger, &process, pod)
+	enforced, err := r.enforceSupplementalGroupsOnProcessSpec(logger, &process, decision)
+	if err != nil {
+		return nil, err
+	}
+	if r.hardenProcessSpec(logger, &process, decision.Action) {
+		enforced = true
+	}
 	if enforced {
 		jsonRaw, err := json.Marshal(&process)
 		if err != nil {
-			return fmt.Errorf("Failed to marshal process spec: %w", err)
+			return nil, fmt.Errorf("Failed to marshal process spec: %w", err)
 		}
 		if err := os.WriteFile(crArgs.Options.Process, jsonRaw, 0644); err != nil {
-			return fmt.Errorf("Failed to update process spec: %w", err)
+			return nil, fmt.Errorf("Failed to update process spec: %w", err)
 		}
 		logger.Info().Msg("SupplementalGroups enforced successfully")
-		return nil
 	}
-	return nil
+	return args, nil
 }
+
 func (r *strictSupplementalGroupsRuntime) getBundleForContainer(root, containerId string) (*bundle.Bundle, error) {
+	root = r.runtimeRoot(root)
+
+	// fast path: read the runtime's state file
+	bundleDir, err := r.getBundleDirFromStateFile(root, containerId)
+	if err != nil {
+		zlog.Debug().Err(err).Msg("Failed to resolve bundle from state file. Fall back to state command")
+		bundleDir, err = r.getBundleDirFromStateCommand(root, containerId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	b, err := bundle.NewBundle(bundleDir)
+	if err != nil {
+		return nil, fmt.Errorf("Fail to load OCI bundle: %v", err)
+	}
+	return b, nil
+}
+
+func (r *strictSupplementalGroupsRuntime) getBundleDirFromStateFile(root, containerId string) (string, error) {
+	profile := r.cfg.ResolvedRuntimeProfile
+	if profile.StateFile == "" {
+		return "", fmt.Errorf("state-file is not configured")
+	}
+	if !isValidContainerId(containerId) {
+		return "", fmt.Errorf("invalid container id %q", containerId)
+	}
+
+	stateFile := strings.NewReplacer("{root}", root, "{id}", containerId).Replace(profile.StateFile)
+	stateRaw, err := os.ReadFile(stateFile)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read state file: %v", err)
+	}
+	return getStateBundle(stateRaw, profile.StateFileBundleField)
+}
+
+func (r *strictSupplementalGroupsRuntime) getBundleDirFromStateCommand(root, containerId string) (string, error) {
 	runtime, err := lookup.LookupExecutable(r.cfg.Runtime)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to find runtime: %v", err)
+		return "", fmt.Errorf("Failed to find runtime: %v", err)
 	}
 
-	command := []string{runtime, "--root", root, "state", containerId}
+	profile := r.cfg.ResolvedRuntimeProfile
+	command := []string{runtime}
+	for _, arg := range profile.StateArgs {
+		command = append(command, strings.NewReplacer("{root}", root, "{id}", containerId).Replace(arg))
+	}
 	cmd := exec.Command(command[0], command[1:]...)
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout, cmd.Stderr = &stdout, &stderr
 	err = cmd.Run()
 	if err != nil {
 		zlog.Error().Err(err).Str("Stdout", stdout.String()).Str("Stderr", stderr.String()).Strs("Command", command).Msg("Failed to execute Command")
-		return nil, fmt.Errorf("Failed to execute command '%s': %v", strings.Join(command, " "), err)
+		return "", fmt.Errorf("Failed to execute command '%s': %v", strings.Join(command, " "), err)
 	}
 
-	stateRaw := stdout.Bytes()
-	var state specs.State
+	return getStateBundle(stdout.Bytes(), profile.StateBundleField)
+}
+
+// getStateBundle returns the bundle directory at the dot separated path field in the state json.
+// "<path>=<key>" field means the value of "<key>=<value>" formatted element in the string array at <path>.
+func getStateBundle(stateRaw []byte, field string) (string, error) {
+	var state interface{}
 	if err := json.Unmarshal(stateRaw, &state); err != nil {
-		return nil, fmt.Errorf("Failed to parse state json: %v", err)
+		return "", fmt.Errorf("Failed to parse state json: %v", err)
 	}
-	b, err := bundle.NewBundle(state.Bundle)
-	if err != nil {
-		return nil, fmt.Errorf("Fail to load OCI bundle: %v", err)
+
+	path, label := field, ""
+	if i := strings.Index(field, "="); i >= 0 {
+		path, label = field[:i], field[i+1:]
 	}
-	return b, nil
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := state.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("Failed to find %s in state json", field)
+		}
+		state = obj[key]
+	}
+	if label != "" {
+		labels, _ := state.([]interface{})
+		state = nil
+		for _, l := range labels {
+			if s, ok := l.(string); ok && strings.HasPrefix(s, label+"=") {
+				state = strings.TrimPrefix(s, label+"=")
+				break
+			}
+		}
+	}
+
+	bundleDir, ok := state.(string)
+	if !ok || bundleDir == "" {
+		return "", fmt.Errorf("Failed to find %s in state json", field)
+	}
+	return bundleDir, nil
 }
 
-func (r *strictSupplementalGroupsRuntime) createContainerLogWriter(crArgs *RuntimeArgs) (io.Writer, func() error, error) {
+func createContainerLogWriter(crArgs *RuntimeArgs) (io.Writer, func() error, error) {
 	containerLogFile := crArgs.Options.Log
 	if containerLogFile == "" {
 		// setup container log (if specified)
@@ -227,16 +374,17 @@ func (r *strictSupplementalGroupsRuntime) createContainerLogWriter(crArgs *Runti
 	if err != nil {
 		return zerolog.Logger{}, nil, fmt.Errorf("Failed to open container log file: %w", err)
 	}
-	var containerLogOutput io.Writer = containerLogWriter
-	if crArgs.Options.LogFormat == "text" {
-		containerLogOutput = zerolog.ConsoleWriter{Out: containerLogWriter, NoColor: true, TimeFormat: time.RFC3339}
-	}
-	return containerLogOutput, containerLogWriter.Close, nil
+	// the container log is in runc's format because CRI implementations read it
+	return newRuncLogWriter(containerLogWriter, crArgs.Options.LogFormat), containerLogWriter.Close, nil
 }
 
+// enforceSupplementalGroupsOnBundle enforces on the OCI bundle.  When checkpointImagePath is not empty,
+// credentials of the checkpointed process which will be restored are also verified.
 func (r *strictSupplementalGroupsRuntime) enforceSupplementalGroupsOnBundle(
 	logger zerolog.Logger,
 	b *bundle.Bundle,
+	crArgs *RuntimeArgs,
+	checkpointImagePath string,
 ) error {
 	// resolve pod's namespace/name and container and its container type(sandbox, container)
 	ctrInfo, err := b.GetContainerInfo(logger, r.cfg)
@@ -256,46 +404,126 @@ func (r *strictSupplementalGroupsRuntime) enforceSupplementalGroupsOnBundle(
 		return nil
 	}
 
-	pod, err := r.kubeletClient.Pod(ctrInfo.PodNamespace, ctrInfo.PodName)
+	if exemption := r.findExemption(ctrInfo); exemption != nil {
+		logger.Info().Interface("Exemption", exemption).Msg("Skip to enforce supplementalGroups for exempted containers")
+		return nil
+	}
+
+	decision, err := r.getEnforcementDecision(logger, crArgs, ctrInfo)
 	if err != nil {
-		return fmt.Errorf("Failed to get pod: %v", err)
+		return err
+	}
+
+	if checkpointImagePath != "" {
+		if err := r.verifyCheckpointedProcess(logger, checkpointImagePath, decision); err != nil {
+			return err
+		}
 	}
 
 	var enforced bool
-	_ = b.DoSpec(func(s *specs.Spec) error {
+	if err := b.DoSpec(func(s *specs.Spec) error {
 		if s.Process == nil {
 			s.Process = &specs.Process{}
 		}
-		enforced = r.enforceSupplementalGroupsOnProcessSpec(logger, s.Process, pod)
+		var err error
+		enforced, err = r.enforceSupplementalGroupsOnProcessSpec(logger, s.Process, decision)
+		if err != nil {
+			return err
+		}
+		hardened, err := r.hardenSpec(logger, s, decision.Action)
+		if err != nil {
+			return err
+		}
+		if hardened {
+			enforced = true
+		}
 		return nil
-	})
+	}); err != nil {
+		return err
+	}
 	if enforced {
 		if err := b.SaveSpec(); err != nil {
 			return fmt.Errorf("Failed to update OCI bundle: %w", err)
 		}
 		logger.Info().Msg("SupplementalGroups enforced successfully")
-		return nil
+	}
+	if crArgs.Command != CommandStart {
+		r.saveEnforcementDecision(logger, crArgs, decision)
 	}
 	return nil
 }
 
+// enforceSupplementalGroupsOnProcessSpec enforces the pod's SecurityContext in the enforcement decision on the process spec
 func (r *strictSupplementalGroupsRuntime) enforceSupplementalGroupsOnProcessSpec(
 	logger zerolog.Logger,
 	processSpec *specs.Process,
-	pod *corev1.Pod,
-) bool /* enforcement performed or not*/ {
+	decision *enforcementDecision,
+) (bool /* enforcement performed or not*/, error) {
+	pod, containerName, action := decision.Pod, decision.ContainerName, decision.Action
+	logger = logger.With().Str("EnforcementAction", string(action)).Logger()
+
+	// the decision on capabilities is logged together with the enforcement of gids
+	capabilitiesDecision, violatedCapabilities, err := r.enforceCapabilitiesOnProcessSpec(logger, processSpec, pod.Namespace, action)
+	if err != nil {
+		return false, err
+	}
+	logger = logger.With().
+		Str("CapabilitiesDecision", string(capabilitiesDecision)).
+		Strs("violatedCapabilities", violatedCapabilities).
+		Logger()
+	capabilitiesEnforced := capabilitiesDecision == capabilitiesDecisionDropped
+
 	// get additionalGids and supplementalGroups
 	additionalGids := r.getAdditionalGids(processSpec)
 	logger.Debug().Interface("additionalGids", additionalGids).Msg("Additional Gids loaded")
 
-	supplementalGroups, fsGroup := r.getSupplementalGroupsAndFsGroup(pod)
-	logger.Debug().Interface("supplementalGroups", supplementalGroups).Interface("fsGroup", fsGroup).Msg("Supplemental Groups And FsGroup loaded")
+	containerSecurityContext, err := r.getContainerSecurityContext(&pod.Pod, containerName)
+	if err != nil {
+		return false, err
+	}
+
+	userEnforced, err := r.enforceUserOnProcessSpec(logger, processSpec, &pod.Pod, containerSecurityContext, action)
+	if err != nil {
+		return false, err
+	}
+
+	supplementalGroups, fsGroup, supplementalGroupsPolicy := r.getSupplementalGroupsAndFsGroup(pod)
+	logger.Debug().
+		Interface("supplementalGroups", supplementalGroups).
+		Interface("fsGroup", fsGroup).
+		Str("supplementalGroupsPolicy", string(supplementalGroupsPolicy)).
+		Msg("Supplemental Groups And FsGroup loaded")
 	allowedGids := GidSet{}
-	for k := range supplementalGroups {
-		allowedGids[k] = struct{}{}
+	for _, gid := range decision.AllowedGids {
+		allowedGids[gid] = struct{}{}
 	}
-	if fsGroup != nil {
-		allowedGids[*fsGroup] = struct{}{}
+	if r.cfg.GroupDatabase.Mode == config.GroupDatabaseModeIntersect || r.cfg.GroupDatabase.Mode == config.GroupDatabaseModeReplace {
+		allowedGids, err = r.enforceGroupDatabaseOnAllowedGids(logger, int64(processSpec.User.UID), allowedGids, action)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	primaryGroupEnforced, err := r.enforcePrimaryGroupOnProcessSpec(logger, processSpec, &pod.Pod, containerSecurityContext, allowedGids, action)
+	if err != nil {
+		return false, err
+	}
+
+	if r.entitlements != nil {
+		if err := r.enforceEntitlementOnUser(logger, processSpec, pod.Namespace, action); err != nil {
+			return false, err
+		}
+	}
+
+	// pods explicitly requesting "Merge" can keep gids defined in the container image if configured so.
+	// "Strict" is enforced as same as pods without supplementalGroupsPolicy even if CRI doesn't support it.
+	if supplementalGroupsPolicy == kubelet.SupplementalGroupsPolicyMerge && r.cfg.MergeSupplementalGroupsPolicy == config.MergeSupplementalGroupsPolicyRespect {
+		logger.Info().
+			Interface("supplementalGroups", supplementalGroups).
+			Interface("fsGroups", fsGroup).
+			Interface("additionalGids", additionalGids).
+			Msg("Skip to enforce additionalGids because supplementalGroupsPolicy is Merge")
+		return capabilitiesEnforced || userEnforced || primaryGroupEnforced, nil
 	}
 
 	// it must satisfies additionalGids ⊆ (supplementalGroups ∪ fsGroup)
@@ -309,15 +537,23 @@ func (r *strictSupplementalGroupsRuntime) enforceSupplementalGroupsOnProcessSpec
 		}
 	}
 	if len(violatedGids) > 0 {
-		logger.Info().
+		sort.Slice(violatedGids, func(i, j int) bool { return violatedGids[i] < violatedGids[j] })
+		logger := logger.With().
 			Ints64("violatedGids", violatedGids).
 			Interface("supplementalGroups", supplementalGroups).
 			Interface("fsGroups", fsGroup).
 			Interface("additionalGids", additionalGids).
 			Interface("enforcedGids", enforcedGids).
-			Msg("Detected violated gids such that it is in additionalGroups but not in (supplementalGroups ∪ fsGroup). Dropping violated Gids")
-		processSpec.User.AdditionalGids = enforcedGids
-		return true
+			Logger()
+		additionalGidsEnforced, err := resolveViolation(
+			logger, action,
+			fmt.Errorf("additionalGids %v are not in (supplementalGroups ∪ fsGroup)", violatedGids),
+			func() {
+				logger.Info().Msg("Detected violated gids such that it is in additionalGroups but not in (supplementalGroups ∪ fsGroup). Dropping violated Gids")
+				processSpec.User.AdditionalGids = enforcedGids
+			},
+		)
+		return capabilitiesEnforced || userEnforced || primaryGroupEnforced || additionalGidsEnforced, err
 	}
 	logger.Info().
 		Interface("supplementalGroups", supplementalGroups).
@@ -325,7 +561,253 @@ func (r *strictSupplementalGroupsRuntime) enforceSupplementalGroupsOnProcessSpec
 		Interface("additionalGids", additionalGids).
 		Msg("No need to replace additionalGids")
 
-	return false
+	return capabilitiesEnforced || userEnforced || primaryGroupEnforced, nil
+}
+
+func (r *strictSupplementalGroupsRuntime) enforceUserOnProcessSpec(
+	logger zerolog.Logger,
+	processSpec *specs.Process,
+	pod *corev1.Pod,
+	containerSecurityContext *corev1.SecurityContext,
+	action config.EnforcementAction,
+) (bool /* enforcement performed or not*/, error) {
+	uid := int64(processSpec.User.UID)
+	logger = logger.With().Int64("uid", uid).Logger()
+
+	// it must satisfies uid == runAsUser when runAsUser is set
+	runAsUser := r.getRunAsUser(pod, containerSecurityContext)
+	if runAsUser == nil {
+		logger.Debug().Msg("runAsUser is not set. Ignored uid")
+		return false, nil
+	}
+	logger = logger.With().Int64("runAsUser", *runAsUser).Logger()
+	if uid == *runAsUser {
+		logger.Info().Msg("No need to replace uid")
+		return false, nil
+	}
+
+	violation := fmt.Errorf("uid %d is not equal to runAsUser %d", uid, *runAsUser)
+	switch r.cfg.RunAsUserPolicy {
+	case config.RunAsUserPolicyReject:
+		return resolveViolation(logger, action, violation, nil)
+	default:
+		return resolveViolation(logger, action, violation, func() {
+			logger.Info().Msg("Detected violated uid such that it is not equal to runAsUser. Replacing uid with runAsUser")
+			processSpec.User.UID = uint32(*runAsUser)
+		})
+	}
+}
+
+func (r *strictSupplementalGroupsRuntime) enforcePrimaryGroupOnProcessSpec(
+	logger zerolog.Logger,
+	processSpec *specs.Process,
+	pod *corev1.Pod,
+	containerSecurityContext *corev1.SecurityContext,
+	allowedGids GidSet,
+	action config.EnforcementAction,
+) (bool /* enforcement performed or not*/, error) {
+	gid := int64(processSpec.User.GID)
+	logger = logger.With().Int64("gid", gid).Logger()
+
+	// it must satisfies gid == runAsGroup when runAsGroup is set
+	if runAsGroup := r.getRunAsGroup(pod, containerSecurityContext); runAsGroup != nil {
+		logger := logger.With().Int64("runAsGroup", *runAsGroup).Logger()
+		if gid == *runAsGroup {
+			logger.Info().Msg("No need to replace gid")
+			return false, nil
+		}
+		return resolveViolation(logger, action, fmt.Errorf("gid %d is not equal to runAsGroup %d", gid, *runAsGroup), func() {
+			logger.Info().Msg("Detected violated gid such that it is not equal to runAsGroup. Replacing gid with runAsGroup")
+			processSpec.User.GID = uint32(*runAsGroup)
+		})
+	}
+
+	switch r.cfg.PrimaryGroupPolicy {
+	case config.PrimaryGroupPolicyForce:
+		forcedGid, ok := r.getDefaultPrimaryGroup(pod)
+		if !ok {
+			return resolveViolation(logger, action, fmt.Errorf("gid %d can not be forced because none of runAsGroup, fsGroup and supplementalGroups is set", gid), nil)
+		}
+		logger := logger.With().Int64("forcedGid", forcedGid).Logger()
+		if gid == forcedGid {
+			logger.Info().Msg("No need to replace gid")
+			return false, nil
+		}
+		return resolveViolation(logger, action, fmt.Errorf("gid %d is not equal to fsGroup or the first supplementalGroups %d", gid, forcedGid), func() {
+			logger.Info().Msg("runAsGroup is not set. Replacing gid with fsGroup or the first supplementalGroups")
+			processSpec.User.GID = uint32(forcedGid)
+		})
+	case config.PrimaryGroupPolicyRestrict:
+		if _, ok := allowedGids[gid]; !ok {
+			return resolveViolation(logger, action, fmt.Errorf("gid %d is not in (supplementalGroups ∪ fsGroup) while runAsGroup is not set", gid), nil)
+		}
+		logger.Info().Msg("runAsGroup is not set but gid is in (supplementalGroups ∪ fsGroup)")
+		return false, nil
+	case config.PrimaryGroupPolicyReject:
+		return resolveViolation(logger, action, fmt.Errorf("gid %d is not allowed because runAsGroup is not set", gid), nil)
+	default:
+		logger.Debug().Msg("runAsGroup is not set. Ignored gid")
+		return false, nil
+	}
+}
+
+// getAllowedGids returns gids allowed by the pod (supplementalGroups ∪ fsGroup) restricted by the namespace's entitlement
+func (r *strictSupplementalGroupsRuntime) getAllowedGids(
+	logger zerolog.Logger,
+	pod *kubelet.Pod,
+	action config.EnforcementAction,
+) (GidSet, error) {
+	supplementalGroups, fsGroup, _ := r.getSupplementalGroupsAndFsGroup(pod)
+	allowedGids := GidSet{}
+	for k := range supplementalGroups {
+		allowedGids[k] = struct{}{}
+	}
+	if fsGroup != nil {
+		allowedGids[*fsGroup] = struct{}{}
+	}
+	if r.entitlements == nil {
+		return allowedGids, nil
+	}
+	logger = logger.With().Str("EnforcementAction", string(action)).Logger()
+	return r.enforceEntitlementOnAllowedGids(logger, pod.Namespace, allowedGids, action)
+}
+
+// enforceEntitlementOnAllowedGids restricts allowedGids(supplementalGroups ∪ fsGroup) with the namespace's entitlement
+func (r *strictSupplementalGroupsRuntime) enforceEntitlementOnAllowedGids(
+	logger zerolog.Logger,
+	namespace string,
+	allowedGids GidSet,
+	action config.EnforcementAction,
+) (GidSet, error) {
+	ent := r.entitlements.Find(namespace)
+	logger = logger.With().Interface("Entitlement", ent).Logger()
+
+	entitledGids := GidSet{}
+	notEntitledGids := []int64{}
+	for gid := range allowedGids {
+		if ent != nil && ent.SupplementalGroups.Contains(gid) {
+			entitledGids[gid] = struct{}{}
+		} else {
+			notEntitledGids = append(notEntitledGids, gid)
+		}
+	}
+	if len(notEntitledGids) == 0 {
+		logger.Debug().Msg("All the gids in (supplementalGroups ∪ fsGroup) are entitled")
+		return allowedGids, nil
+	}
+	sort.Slice(notEntitledGids, func(i, j int) bool { return notEntitledGids[i] < notEntitledGids[j] })
+	logger = logger.With().Ints64("notEntitledGids", notEntitledGids).Logger()
+
+	var fix func()
+	if r.cfg.EntitlementPolicy == config.EntitlementPolicyIntersect {
+		fix = func() {
+			logger.Info().Msg("Detected gids in (supplementalGroups ∪ fsGroup) not entitled to the namespace. Dropping them from allowed gids")
+			allowedGids = entitledGids
+		}
+	}
+	violation := fmt.Errorf("supplementalGroups/fsGroup %v are not entitled to namespace %s", notEntitledGids, namespace)
+	if _, err := resolveViolation(logger, action, violation, fix); err != nil {
+		return nil, err
+	}
+	return allowedGids, nil
+}
+
+// enforceGroupDatabaseOnAllowedGids restricts or replaces allowedGids with gids which the uid belongs to in the group database
+func (r *strictSupplementalGroupsRuntime) enforceGroupDatabaseOnAllowedGids(
+	logger zerolog.Logger,
+	uid int64,
+	allowedGids GidSet,
+	action config.EnforcementAction,
+) (GidSet, error) {
+	dbCfg := r.cfg.GroupDatabase
+	memberGids := GidSet{}
+	gids, err := groupdb.LookupGroups(dbCfg.PasswdFile, dbCfg.GroupFile, uid)
+	if err != nil {
+		// the uid belongs to no groups when it is not found
+		logger.Warn().Err(err).Msg("Failed to lookup groups in the group database. No gids are allowed by the group database")
+	}
+	for _, gid := range gids {
+		memberGids[gid] = struct{}{}
+	}
+	logger = logger.With().Str("GroupDatabaseMode", string(dbCfg.Mode)).Interface("memberGids", memberGids).Logger()
+
+	if dbCfg.Mode == config.GroupDatabaseModeReplace {
+		logger.Info().Msg("Replaced allowed gids with gids the uid belongs to in the group database")
+		return memberGids, nil
+	}
+
+	intersectedGids := GidSet{}
+	nonMemberGids := []int64{}
+	for gid := range allowedGids {
+		if _, ok := memberGids[gid]; ok {
+			intersectedGids[gid] = struct{}{}
+		} else {
+			nonMemberGids = append(nonMemberGids, gid)
+		}
+	}
+	if len(nonMemberGids) == 0 {
+		logger.Debug().Msg("The uid belongs to all the gids in (supplementalGroups ∪ fsGroup) in the group database")
+		return allowedGids, nil
+	}
+	sort.Slice(nonMemberGids, func(i, j int) bool { return nonMemberGids[i] < nonMemberGids[j] })
+	logger = logger.With().Ints64("nonMemberGids", nonMemberGids).Logger()
+
+	violation := fmt.Errorf("uid %d does not belong to supplementalGroups/fsGroup %v in the group database", uid, nonMemberGids)
+	if _, err := resolveViolation(logger, action, violation, func() {
+		logger.Info().Msg("Detected gids in (supplementalGroups ∪ fsGroup) which the uid does not belong to. Dropping them from allowed gids")
+		allowedGids = intersectedGids
+	}); err != nil {
+		return nil, err
+	}
+	return allowedGids, nil
+}
+
+// enforceEntitlementOnUser checks uid and gid of the process spec are entitled to the namespace
+func (r *strictSupplementalGroupsRuntime) enforceEntitlementOnUser(
+	logger zerolog.Logger,
+	processSpec *specs.Process,
+	namespace string,
+	action config.EnforcementAction,
+) error {
+	ent := r.entitlements.Find(namespace)
+	logger = logger.With().Interface("Entitlement", ent).Logger()
+
+	uid, gid := int64(processSpec.User.UID), int64(processSpec.User.GID)
+	if ent == nil || !ent.Uids.Contains(uid) {
+		if _, err := resolveViolation(logger, action, fmt.Errorf("uid %d is not entitled to namespace %s", uid, namespace), nil); err != nil {
+			return err
+		}
+	}
+	if ent == nil || !ent.Gids.Contains(gid) {
+		if _, err := resolveViolation(logger, action, fmt.Errorf("gid %d is not entitled to namespace %s", gid, namespace), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveViolation handles a detected violation according to the enforcement action.
+// fix corrects the process spec and it is called only in "drop" action.
+// When fix is nil, the violation can not be corrected and it is returned as an error except in "audit" action.
+func resolveViolation(
+	logger zerolog.Logger,
+	action config.EnforcementAction,
+	violation error,
+	fix func(),
+) (bool /* enforcement performed or not*/, error) {
+	switch action {
+	case config.EnforcementActionAudit:
+		logger.Warn().Err(violation).Msg("Detected violation. Not enforced because of audit action")
+		return false, nil
+	case config.EnforcementActionDeny:
+		return false, fmt.Errorf("Denied the container: %w", violation)
+	default:
+		if fix == nil {
+			return false, fmt.Errorf("Rejected the container: %w", violation)
+		}
+		fix()
+		return true, nil
+	}
 }
 
 func (r *strictSupplementalGroupsRuntime) getAdditionalGids(process *specs.Process) GidSet {
@@ -341,15 +823,81 @@ func (r *strictSupplementalGroupsRuntime) getAdditionalGids(process *specs.Proce
 	return additionalGids
 }
 
-func (r *strictSupplementalGroupsRuntime) getSupplementalGroupsAndFsGroup(pod *corev1.Pod) (GidSet, *int64) {
+func (r *strictSupplementalGroupsRuntime) getSupplementalGroupsAndFsGroup(pod *kubelet.Pod) (GidSet, *int64, kubelet.SupplementalGroupsPolicy) {
+	var supplementalGroupsPolicy kubelet.SupplementalGroupsPolicy
+	if pod.SupplementalGroupsPolicy != nil {
+		supplementalGroupsPolicy = *pod.SupplementalGroupsPolicy
+	}
+
 	supplementalGroups := GidSet{}
 	if pod.Spec.SecurityContext == nil {
-		return supplementalGroups, nil
+		return supplementalGroups, nil, supplementalGroupsPolicy
 	}
 
 	for _, gid := range pod.Spec.SecurityContext.SupplementalGroups {
 		supplementalGroups[gid] = struct{}{}
 	}
 
-	return supplementalGroups, pod.Spec.SecurityContext.FSGroup
+	return supplementalGroups, pod.Spec.SecurityContext.FSGroup, supplementalGroupsPolicy
+}
+
+// getRunAsUser returns effective runAsUser of the container.  Container's SecurityContext takes precedence over pod's one.
+func (r *strictSupplementalGroupsRuntime) getRunAsUser(pod *corev1.Pod, containerSecurityContext *corev1.SecurityContext) *int64 {
+	if containerSecurityContext != nil && containerSecurityContext.RunAsUser != nil {
+		return containerSecurityContext.RunAsUser
+	}
+	if pod.Spec.SecurityContext == nil {
+		return nil
+	}
+	return pod.Spec.SecurityContext.RunAsUser
+}
+
+// getRunAsGroup returns effective runAsGroup of the container.  Container's SecurityContext takes precedence over pod's one.
+func (r *strictSupplementalGroupsRuntime) getRunAsGroup(pod *corev1.Pod, containerSecurityContext *corev1.SecurityContext) *int64 {
+	if containerSecurityContext != nil && containerSecurityContext.RunAsGroup != nil {
+		return containerSecurityContext.RunAsGroup
+	}
+	if pod.Spec.SecurityContext == nil {
+		return nil
+	}
+	return pod.Spec.SecurityContext.RunAsGroup
+}
+
+// getDefaultPrimaryGroup returns fsGroup, or the first supplementalGroups if fsGroup is not set.
+func (r *strictSupplementalGroupsRuntime) getDefaultPrimaryGroup(pod *corev1.Pod) (int64, bool) {
+	if pod.Spec.SecurityContext == nil {
+		return 0, false
+	}
+	if pod.Spec.SecurityContext.FSGroup != nil {
+		return *pod.Spec.SecurityContext.FSGroup, true
+	}
+	if len(pod.Spec.SecurityContext.SupplementalGroups) > 0 {
+		return pod.Spec.SecurityContext.SupplementalGroups[0], true
+	}
+	return 0, false
+}
+
+// getContainerSecurityContext finds the container by name in containers, initContainers and ephemeralContainers
+// and returns its SecurityContext. It fails when the container is not found in the pod.
+func (r *strictSupplementalGroupsRuntime) getContainerSecurityContext(pod *corev1.Pod, containerName string) (*corev1.SecurityContext, error) {
+	if containerName == "" {
+		// container name annotation is not available. only pod's SecurityContext is used.
+		return nil, nil
+	}
+	for _, c := range pod.Spec.Containers {
+		if c.Name == containerName {
+			return c.SecurityContext, nil
+		}
+	}
+	for _, c := range pod.Spec.InitContainers {
+		if c.Name == containerName {
+			return c.SecurityContext, nil
+		}
+	}
+	for _, c := range pod.Spec.EphemeralContainers {
+		if c.Name == containerName {
+			return c.SecurityContext, nil
+		}
+	}
+	return nil, fmt.Errorf("Container %s not found in pod %s/%s", containerName, pod.Namespace, pod.Name)
 }
@@ -1,6 +1,8 @@
 package runtime
 
 import (
+	"os"
+	"path/filepath"
 	"sort"
 
 	. "github.com/onsi/ginkgo/v2"
@@ -8,16 +10,39 @@ import (
 
 	zlog "github.com/rs/zerolog/log"
 
+	"github.com/mcuadros/go-defaults"
 	"github.com/opencontainers/runtime-spec/specs-go"
 
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/utils/pointer"
+
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/config"
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/entitlement"
+	"github.com/pfnet-research/strict-supplementalgroups-container-runtime/pkg/kubelet"
 )
 
+// enforceOnProcessSpec enforces the pod on the process spec with the enforcement decision made as on create
+func enforceOnProcessSpec(
+	r *strictSupplementalGroupsRuntime,
+	processSpec *specs.Process,
+	pod *kubelet.Pod,
+	containerName string,
+	action config.EnforcementAction,
+) (bool, error) {
+	decision, err := r.newEnforcementDecision(zlog.Logger, pod, containerName, action)
+	if err != nil {
+		return false, err
+	}
+	return r.enforceSupplementalGroupsOnProcessSpec(zlog.Logger, processSpec, decision)
+}
+
 var _ = Describe("enforceSupplementalGroupsOnProcessSpec", func() {
 	uid := uint32(1000)
 	gid := uint32(1000)
-	r := strictSupplementalGroupsRuntime{}
+	r := strictSupplementalGroupsRuntime{
+		cfg: &config.Config{PrimaryGroupPolicy: config.PrimaryGroupPolicyIgnore},
+	}
 
 	testFunc := func(
 		additionalGids []uint32,
@@ -45,7 +70,8 @@ var _ = Describe("enforceSupplementalGroupsOnProcessSpec", func() {
 			},
 		}
 
-		enforced := r.enforceSupplementalGroupsOnProcessSpec(zlog.Logger, &processSpec, &pod)
+		enforced, err := enforceOnProcessSpec(&r, &processSpec, &kubelet.Pod{Pod: pod}, "", config.EnforcementActionDrop)
+		Expect(err).NotTo(HaveOccurred())
 		Expect(enforced).To(Equal(expectEnforced))
 		sort.Slice(processSpec.User.AdditionalGids, func(i, j int) bool {
 			return processSpec.User.AdditionalGids[i] < processSpec.User.AdditionalGids[j]
@@ -87,3 +113,394 @@ var _ = Describe("enforceSupplementalGroupsOnProcessSpec", func() {
 		})
 	})
 })
+
+var _ = Describe("enforcePrimaryGroupOnProcessSpec", func() {
+	containerName := "ctr"
+	uid := uint32(1000)
+
+	testFunc := func(
+		policy config.PrimaryGroupPolicy,
+		gid uint32,
+		podSecurityContext *corev1.PodSecurityContext,
+		containerSecurityContext *corev1.SecurityContext,
+		expectEnforced bool,
+		expectErr bool,
+		expectedGid uint32,
+	) {
+		r := strictSupplementalGroupsRuntime{cfg: &config.Config{PrimaryGroupPolicy: policy}}
+		processSpec := specs.Process{
+			User: specs.User{UID: uid, GID: gid},
+		}
+		pod := corev1.Pod{
+			Spec: corev1.PodSpec{
+				SecurityContext: podSecurityContext,
+				Containers: []corev1.Container{{
+					Name:            containerName,
+					SecurityContext: containerSecurityContext,
+				}},
+			},
+		}
+
+		enforced, err := enforceOnProcessSpec(&r, &processSpec, &kubelet.Pod{Pod: pod}, containerName, config.EnforcementActionDrop)
+		if expectErr {
+			Expect(err).To(HaveOccurred())
+			return
+		}
+		Expect(err).NotTo(HaveOccurred())
+		Expect(enforced).To(Equal(expectEnforced))
+		Expect(processSpec.User.GID).To(Equal(expectedGid))
+	}
+
+	When("runAsGroup is set", func() {
+		DescribeTable(
+			"test",
+			testFunc,
+			Entry("pod runAsGroup == gid", config.PrimaryGroupPolicyIgnore, uint32(1000),
+				&corev1.PodSecurityContext{RunAsGroup: pointer.Int64(1000)}, nil, false, false, uint32(1000)),
+			Entry("pod runAsGroup != gid", config.PrimaryGroupPolicyIgnore, uint32(50000),
+				&corev1.PodSecurityContext{RunAsGroup: pointer.Int64(1000)}, nil, true, false, uint32(1000)),
+			Entry("container runAsGroup overrides pod runAsGroup", config.PrimaryGroupPolicyIgnore, uint32(1000),
+				&corev1.PodSecurityContext{RunAsGroup: pointer.Int64(1000)}, &corev1.SecurityContext{RunAsGroup: pointer.Int64(2000)}, true, false, uint32(2000)),
+			Entry("container runAsGroup without pod runAsGroup", config.PrimaryGroupPolicyReject, uint32(2000),
+				nil, &corev1.SecurityContext{RunAsGroup: pointer.Int64(2000)}, false, false, uint32(2000)),
+		)
+	})
+
+	When("runAsGroup is not set", func() {
+		DescribeTable(
+			"test",
+			testFunc,
+			Entry("ignore", config.PrimaryGroupPolicyIgnore, uint32(50000),
+				&corev1.PodSecurityContext{SupplementalGroups: []int64{60000}}, nil, false, false, uint32(50000)),
+			Entry("force with fsGroup", config.PrimaryGroupPolicyForce, uint32(50000),
+				&corev1.PodSecurityContext{SupplementalGroups: []int64{60000}, FSGroup: pointer.Int64(70000)}, nil, true, false, uint32(70000)),
+			Entry("force with supplementalGroups", config.PrimaryGroupPolicyForce, uint32(50000),
+				&corev1.PodSecurityContext{SupplementalGroups: []int64{60000, 60001}}, nil, true, false, uint32(60000)),
+			Entry("force without fsGroup and supplementalGroups", config.PrimaryGroupPolicyForce, uint32(50000),
+				nil, nil, false, true, uint32(0)),
+			Entry("restrict with allowed gid", config.PrimaryGroupPolicyRestrict, uint32(60000),
+				&corev1.PodSecurityContext{SupplementalGroups: []int64{60000}}, nil, false, false, uint32(60000)),
+			Entry("restrict with violated gid", config.PrimaryGroupPolicyRestrict, uint32(50000),
+				&corev1.PodSecurityContext{SupplementalGroups: []int64{60000}}, nil, false, true, uint32(0)),
+			Entry("reject", config.PrimaryGroupPolicyReject, uint32(60000),
+				&corev1.PodSecurityContext{SupplementalGroups: []int64{60000}}, nil, false, true, uint32(0)),
+		)
+
+		It("rejects the primary group not in (supplementalGroups ∪ fsGroup) by default", func() {
+			cfg := &config.Config{}
+			defaults.SetDefaults(cfg)
+			Expect(cfg.PrimaryGroupPolicy).To(Equal(config.PrimaryGroupPolicyRestrict))
+			testFunc(cfg.PrimaryGroupPolicy, uint32(50000),
+				&corev1.PodSecurityContext{SupplementalGroups: []int64{60000}}, nil, false, true, uint32(0))
+		})
+	})
+})
+
+var _ = Describe("enforceUserOnProcessSpec", func() {
+	gid := uint32(1000)
+
+	testFunc := func(
+		policy config.RunAsUserPolicy,
+		uid uint32,
+		pod corev1.Pod,
+		containerName string,
+		expectEnforced bool,
+		expectErr bool,
+		expectedUid uint32,
+	) {
+		r := strictSupplementalGroupsRuntime{cfg: &config.Config{
+			PrimaryGroupPolicy: config.PrimaryGroupPolicyIgnore,
+			RunAsUserPolicy:    policy,
+		}}
+		processSpec := specs.Process{
+			User: specs.User{UID: uid, GID: gid},
+		}
+
+		enforced, err := enforceOnProcessSpec(&r, &processSpec, &kubelet.Pod{Pod: pod}, containerName, config.EnforcementActionDrop)
+		if expectErr {
+			Expect(err).To(HaveOccurred())
+			return
+		}
+		Expect(err).NotTo(HaveOccurred())
+		Expect(enforced).To(Equal(expectEnforced))
+		Expect(processSpec.User.UID).To(Equal(expectedUid))
+	}
+
+	podWithRunAsUser := func(podRunAsUser, containerRunAsUser *int64) corev1.Pod {
+		var containerSecurityContext *corev1.SecurityContext
+		if containerRunAsUser != nil {
+			containerSecurityContext = &corev1.SecurityContext{RunAsUser: containerRunAsUser}
+		}
+		return corev1.Pod{
+			Spec: corev1.PodSpec{
+				SecurityContext: &corev1.PodSecurityContext{RunAsUser: podRunAsUser},
+				Containers:      []corev1.Container{{Name: "ctr"}},
+				InitContainers:  []corev1.Container{{Name: "init", SecurityContext: containerSecurityContext}},
+				EphemeralContainers: []corev1.EphemeralContainer{{
+					EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debug", SecurityContext: containerSecurityContext},
+				}},
+			},
+		}
+	}
+
+	DescribeTable(
+		"test",
+		testFunc,
+		Entry("runAsUser is not set", config.RunAsUserPolicyReject, uint32(0),
+			podWithRunAsUser(nil, nil), "ctr", false, false, uint32(0)),
+		Entry("pod runAsUser == uid", config.RunAsUserPolicyReject, uint32(1000),
+			podWithRunAsUser(pointer.Int64(1000), nil), "ctr", false, false, uint32(1000)),
+		Entry("pod runAsUser != uid (rewrite)", config.RunAsUserPolicyRewrite, uint32(0),
+			podWithRunAsUser(pointer.Int64(1000), nil), "ctr", true, false, uint32(1000)),
+		Entry("pod runAsUser != uid (reject)", config.RunAsUserPolicyReject, uint32(0),
+			podWithRunAsUser(pointer.Int64(1000), nil), "ctr", false, true, uint32(0)),
+		Entry("initContainer runAsUser overrides pod runAsUser", config.RunAsUserPolicyRewrite, uint32(1000),
+			podWithRunAsUser(pointer.Int64(1000), pointer.Int64(2000)), "init", true, false, uint32(2000)),
+		Entry("ephemeralContainer runAsUser overrides pod runAsUser", config.RunAsUserPolicyReject, uint32(1000),
+			podWithRunAsUser(pointer.Int64(1000), pointer.Int64(2000)), "debug", false, true, uint32(0)),
+		Entry("container not found in pod", config.RunAsUserPolicyRewrite, uint32(1000),
+			podWithRunAsUser(pointer.Int64(1000), nil), "unknown", false, true, uint32(0)),
+	)
+})
+
+var _ = Describe("EnforcementAction", func() {
+	r := strictSupplementalGroupsRuntime{cfg: &config.Config{
+		PrimaryGroupPolicy: config.PrimaryGroupPolicyReject,
+		RunAsUserPolicy:    config.RunAsUserPolicyRewrite,
+	}}
+	pod := corev1.Pod{
+		Spec: corev1.PodSpec{
+			SecurityContext: &corev1.PodSecurityContext{
+				RunAsUser:          pointer.Int64(1000),
+				RunAsGroup:         pointer.Int64(1000),
+				SupplementalGroups: []int64{60000},
+			},
+		},
+	}
+	newProcessSpec := func() specs.Process {
+		return specs.Process{
+			User: specs.User{UID: 1000, GID: 1000, AdditionalGids: []uint32{50001, 60000, 50000}},
+		}
+	}
+
+	It("drop: drops violated gids", func() {
+		processSpec := newProcessSpec()
+		enforced, err := enforceOnProcessSpec(&r, &processSpec, &kubelet.Pod{Pod: pod}, "", config.EnforcementActionDrop)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(enforced).To(BeTrue())
+		Expect(processSpec.User.AdditionalGids).To(Equal([]uint32{60000}))
+	})
+
+	It("deny: returns an error naming violated gids", func() {
+		processSpec := newProcessSpec()
+		_, err := enforceOnProcessSpec(&r, &processSpec, &kubelet.Pod{Pod: pod}, "", config.EnforcementActionDeny)
+		Expect(err).To(MatchError(ContainSubstring("[50000 50001]")))
+		Expect(processSpec).To(Equal(newProcessSpec()))
+	})
+
+	It("deny: returns an error for violated uid", func() {
+		processSpec := newProcessSpec()
+		processSpec.User.UID = 0
+		_, err := enforceOnProcessSpec(&r, &processSpec, &kubelet.Pod{Pod: pod}, "", config.EnforcementActionDeny)
+		Expect(err).To(MatchError(ContainSubstring("uid 0")))
+	})
+
+	It("audit: changes nothing", func() {
+		processSpec := newProcessSpec()
+		processSpec.User.UID = 0
+		processSpec.User.GID = 0
+		enforced, err := enforceOnProcessSpec(&r, &processSpec, &kubelet.Pod{Pod: pod}, "", config.EnforcementActionAudit)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(enforced).To(BeFalse())
+		expected := newProcessSpec()
+		expected.User.UID = 0
+		expected.User.GID = 0
+		Expect(processSpec).To(Equal(expected))
+	})
+
+	It("audit: does not reject the container even if the policy rejects it", func() {
+		processSpec := newProcessSpec()
+		noRunAsGroupPod := pod.DeepCopy()
+		noRunAsGroupPod.Spec.SecurityContext.RunAsGroup = nil
+		enforced, err := enforceOnProcessSpec(&r, &processSpec, &kubelet.Pod{Pod: *noRunAsGroupPod}, "", config.EnforcementActionAudit)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(enforced).To(BeFalse())
+
+		_, err = enforceOnProcessSpec(&r, &processSpec, &kubelet.Pod{Pod: *noRunAsGroupPod}, "", config.EnforcementActionDrop)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("Entitlement", func() {
+	entitlements := &entitlement.Entitlements{
+		Namespaces: []entitlement.Namespace{{
+			Namespace:          "user-alice",
+			Uids:               entitlement.IdRanges{{Min: 1000, Max: 1000}},
+			Gids:               entitlement.IdRanges{{Min: 1000, Max: 1000}},
+			SupplementalGroups: entitlement.IdRanges{{Min: 60000, Max: 60000}},
+		}},
+	}
+	newRuntime := func(policy config.EntitlementPolicy) strictSupplementalGroupsRuntime {
+		return strictSupplementalGroupsRuntime{
+			cfg: &config.Config{
+				PrimaryGroupPolicy: config.PrimaryGroupPolicyIgnore,
+				RunAsUserPolicy:    config.RunAsUserPolicyRewrite,
+				EntitlementPolicy:  policy,
+			},
+			entitlements: entitlements,
+		}
+	}
+	newPod := func(namespace string, runAsUser int64, supplementalGroups []int64) *kubelet.Pod {
+		return &kubelet.Pod{Pod: corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "pod"},
+			Spec: corev1.PodSpec{
+				SecurityContext: &corev1.PodSecurityContext{
+					RunAsUser:          pointer.Int64(runAsUser),
+					RunAsGroup:         pointer.Int64(1000),
+					SupplementalGroups: supplementalGroups,
+				},
+			},
+		}}
+	}
+	newProcessSpec := func() specs.Process {
+		return specs.Process{
+			User: specs.User{UID: 1000, GID: 1000, AdditionalGids: []uint32{50000, 60000, 60001}},
+		}
+	}
+
+	It("intersect: drops gids not entitled", func() {
+		r := newRuntime(config.EntitlementPolicyIntersect)
+		processSpec := newProcessSpec()
+		enforced, err := enforceOnProcessSpec(&r, &processSpec, newPod("user-alice", 1000, []int64{60000, 60001}), "", config.EnforcementActionDrop)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(enforced).To(BeTrue())
+		Expect(processSpec.User.AdditionalGids).To(Equal([]uint32{60000}))
+	})
+
+	It("reject: rejects gids not entitled", func() {
+		r := newRuntime(config.EntitlementPolicyReject)
+		processSpec := newProcessSpec()
+		_, err := enforceOnProcessSpec(&r, &processSpec, newPod("user-alice", 1000, []int64{60000, 60001}), "", config.EnforcementActionDrop)
+		Expect(err).To(MatchError(ContainSubstring("[60001]")))
+	})
+
+	It("rejects uid not entitled", func() {
+		r := newRuntime(config.EntitlementPolicyIntersect)
+		processSpec := newProcessSpec()
+		_, err := enforceOnProcessSpec(&r, &processSpec, newPod("user-alice", 0, []int64{60000}), "", config.EnforcementActionDrop)
+		Expect(err).To(MatchError(ContainSubstring("uid 0")))
+	})
+
+	It("rejects namespace without entitlement", func() {
+		r := newRuntime(config.EntitlementPolicyIntersect)
+		processSpec := newProcessSpec()
+		_, err := enforceOnProcessSpec(&r, &processSpec, newPod("user-bob", 1000, nil), "", config.EnforcementActionDrop)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("GroupDatabase", func() {
+	var dbCfg config.GroupDatabaseConfig
+	BeforeEach(func() {
+		dir := GinkgoT().TempDir()
+		dbCfg = config.GroupDatabaseConfig{
+			PasswdFile: filepath.Join(dir, "passwd"),
+			GroupFile:  filepath.Join(dir, "group"),
+		}
+		Expect(os.WriteFile(dbCfg.PasswdFile, []byte("alice:x:1000:1000::/home/alice:/bin/bash\n"), 0644)).To(Succeed())
+		Expect(os.WriteFile(dbCfg.GroupFile, []byte("alice:x:1000:\ngroup-foo:x:60000:alice\ngroup-bar:x:60001:alice\n"), 0644)).To(Succeed())
+	})
+	pod := &corev1.Pod{
+		Spec: corev1.PodSpec{
+			SecurityContext: &corev1.PodSecurityContext{
+				RunAsUser:          pointer.Int64(1000),
+				RunAsGroup:         pointer.Int64(1000),
+				SupplementalGroups: []int64{60000, 70000},
+			},
+		},
+	}
+
+	DescribeTable("test",
+		func(mode config.GroupDatabaseMode, uid uint32, expectedAdditionalGids []uint32) {
+			dbCfg.Mode = mode
+			r := strictSupplementalGroupsRuntime{cfg: &config.Config{
+				PrimaryGroupPolicy: config.PrimaryGroupPolicyIgnore,
+				RunAsUserPolicy:    config.RunAsUserPolicyRewrite,
+				GroupDatabase:      dbCfg,
+			}}
+			processSpec := specs.Process{
+				User: specs.User{UID: uid, GID: 1000, AdditionalGids: []uint32{50000, 60000, 60001, 70000}},
+			}
+			_, err := enforceOnProcessSpec(&r, &processSpec, &kubelet.Pod{Pod: *pod}, "", config.EnforcementActionDrop)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(processSpec.User.AdditionalGids).To(ConsistOf(expectedAdditionalGids))
+		},
+		Entry("disabled", config.GroupDatabaseModeDisabled, uint32(1000), []uint32{60000, 70000}),
+		Entry("intersect", config.GroupDatabaseModeIntersect, uint32(1000), []uint32{60000}),
+		Entry("replace", config.GroupDatabaseModeReplace, uint32(1000), []uint32{60000, 60001}),
+		Entry("uid is rewritten before looking up the group database", config.GroupDatabaseModeReplace, uint32(0), []uint32{60000, 60001}),
+	)
+})
+
+var _ = Describe("SupplementalGroupsPolicy", func() {
+	newPod := func(policy *kubelet.SupplementalGroupsPolicy) *kubelet.Pod {
+		return &kubelet.Pod{
+			Pod: corev1.Pod{
+				Spec: corev1.PodSpec{
+					SecurityContext: &corev1.PodSecurityContext{
+						RunAsUser:          pointer.Int64(1000),
+						RunAsGroup:         pointer.Int64(1000),
+						SupplementalGroups: []int64{60000},
+					},
+				},
+			},
+			SupplementalGroupsPolicy: policy,
+		}
+	}
+	merge := kubelet.SupplementalGroupsPolicyMerge
+	strict := kubelet.SupplementalGroupsPolicyStrict
+
+	DescribeTable("test",
+		func(configPolicy config.MergeSupplementalGroupsPolicy, podPolicy *kubelet.SupplementalGroupsPolicy, expectedAdditionalGids []uint32) {
+			r := strictSupplementalGroupsRuntime{cfg: &config.Config{
+				PrimaryGroupPolicy:            config.PrimaryGroupPolicyIgnore,
+				RunAsUserPolicy:               config.RunAsUserPolicyRewrite,
+				MergeSupplementalGroupsPolicy: configPolicy,
+			}}
+			processSpec := specs.Process{
+				User: specs.User{UID: 1000, GID: 1000, AdditionalGids: []uint32{50000, 60000}},
+			}
+			_, err := enforceOnProcessSpec(&r, &processSpec, newPod(podPolicy), "", config.EnforcementActionDrop)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(processSpec.User.AdditionalGids).To(ConsistOf(expectedAdditionalGids))
+		},
+		Entry("not set", config.MergeSupplementalGroupsPolicyRespect, nil, []uint32{60000}),
+		Entry("Strict", config.MergeSupplementalGroupsPolicyRespect, &strict, []uint32{60000}),
+		Entry("Merge (override)", config.MergeSupplementalGroupsPolicyOverride, &merge, []uint32{60000}),
+		Entry("Merge (respect)", config.MergeSupplementalGroupsPolicyRespect, &merge, []uint32{50000, 60000}),
+	)
+})
+
+var _ = Describe("getStateBundle", func() {
+	DescribeTable("finds the bundle in state json",
+		func(state string, field string, expected string) {
+			got, err := getStateBundle([]byte(state), field)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got).To(Equal(expected))
+		},
+		Entry("runc", `{"ociVersion":"1.0.2","id":"ctr","status":"created","bundle":"/run/bundle"}`, "bundle", "/run/bundle"),
+		Entry("nested field", `{"state":{"bundle":"/run/bundle"}}`, "state.bundle", "/run/bundle"),
+		Entry("runc state file", `{"id":"ctr","config":{"labels":["io.kubernetes.cri.container-type=container","bundle=/run/bundle"]}}`, "config.labels=bundle", "/run/bundle"),
+	)
+
+	DescribeTable("fails",
+		func(state string, field string) {
+			_, err := getStateBundle([]byte(state), field)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("invalid json", `{`, "bundle"),
+		Entry("missing field", `{"id":"ctr"}`, "bundle"),
+		Entry("not a string", `{"bundle":{"path":"/run/bundle"}}`, "bundle"),
+		Entry("missing label", `{"config":{"labels":["bundled=/run/bundle"]}}`, "config.labels=bundle"),
+	)
+})
@@ -3,3 +3,9 @@ package runtime
 type Interface interface {
 	Exec(args []string) error
 }
+
+// childRuntime is a runtime which can also be run as a child process of this process
+type childRuntime interface {
+	Interface
+	Run(args []string) error
+}